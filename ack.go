@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// When DATA_ACK may cover a record, by the collector "ack-policy".
+type AckPolicy string
+
+const (
+	// Once the record is synced to the outputs, the default.
+	ACK_DURABLE AckPolicy = "durable"
+	// Once the record is received, whether stored or not.
+	ACK_RECEIVED AckPolicy = "received"
+)
+
+// A record written, or skipped as already stored.
+func (s *Session) recordStored(seq uint64, status SeqStatus) {
+	if seq > s.StoredSeq || status == SEQ_REGRESSION {
+		s.StoredSeq = seq
+	}
+}
+
+func (s *Session) writeFailed(err error) {
+	s.WriteFailed = true
+	s.Stats.WriteErrors++
+	s.exporter.logf("Sess %d: write failed at seq %d, stop acking after seq %d: %s\n",
+		s.Id, s.LastSeq, s.DurableSeq, err)
+}
+
+// Sync the outputs written since the last sync.
+func (s *Session) syncOutputs() error {
+	for _, t := range s.outputTemplates() {
+		if !t.Dirty {
+			continue
+		}
+		for _, f := range []*os.File{t.Output, t.DupOutput} {
+			if f == nil {
+				continue
+			}
+			if err := f.Sync(); err != nil {
+				return fmt.Errorf("sync %s: %s", f.Name(), err)
+			}
+		}
+		t.Dirty = false
+	}
+	return s.syncQuarantine()
+}
+
+// The seq a DATA_ACK may carry now, false if no ack may be sent.
+func (s *Session) ackSeq() (uint64, bool) {
+	if GetAckPolicy() == ACK_RECEIVED {
+		return s.LastSeq, true
+	}
+	if s.WriteFailed {
+		return 0, false
+	}
+	if err := s.syncOutputs(); err != nil {
+		s.writeFailed(err)
+		return 0, false
+	}
+	s.DurableSeq = s.StoredSeq
+	return s.DurableSeq, true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// Exporter with a started primary session 1, acking every 2 records.
+func testAckSession(t *testing.T) (*Exporter, *Session) {
+	e := NewExporter(&ConfigExporter{Name: "ack"})
+	e.sendChan = make(chan []byte, 100)
+	e.SetConnState(CONN_CONNECTED)
+	e.StartFlows([]byte{1})
+	e.handleMsg(nil, testTemplateData(1))
+	e.handleMsg(nil, &SessionStart{
+		Header:              MsgHdr{MsgId: SESSION_START, SessId: 1},
+		Primary:             1,
+		AckTimeInterval:     60,
+		AckSequenceInterval: 2,
+		DocumentID:          make([]byte, 16),
+	})
+	s := e.sessions[1]
+	if s == nil || !s.IsStarted() || len(s.Templates) != 1 || s.Templates[0].Output == nil {
+		t.Fatal("session not started")
+	}
+	return e, s
+}
+
+func testData(e *Exporter, seqs ...uint64) {
+	for _, seq := range seqs {
+		e.handleMsg(nil, &Data{
+			Header:      MsgHdr{MsgId: DATA, SessId: 1},
+			TemplateID:  1,
+			ConfigID:    1,
+			SequenceNum: seq,
+			Record:      []byte{0, 0, 0, 4, 0, 0, 0, 1},
+		})
+	}
+}
+
+// Seqs of the DATA_ACKs sent so far.
+func testAcks(e *Exporter) []uint64 {
+	acks := []uint64{}
+	for {
+		select {
+		case b := <-e.sendChan:
+			if MessageID(b[1]) == DATA_ACK {
+				acks = append(acks, binary.BigEndian.Uint64(b[10:18]))
+			}
+		default:
+			return acks
+		}
+	}
+}
+
+func TestDurableAckFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tc := range []struct {
+		name string
+		fail func(e *Exporter, s *Session)
+	}{
+		// The next record is not written.
+		{"write", func(e *Exporter, s *Session) {
+			s.Templates[0].Output.Close()
+			testData(e, 2)
+		}},
+		// The record is written, the sync before the ack fails.
+		{"sync", func(e *Exporter, s *Session) {
+			testData(e, 2)
+			s.Templates[0].Output.Close()
+			s.sendAck()
+		}},
+	} {
+		e, s := testAckSession(t)
+		testData(e, 0, 1)
+		if acks := testAcks(e); len(acks) != 1 || acks[0] != 1 {
+			t.Fatalf("%s: acks %v before the failure, want [1]", tc.name, acks)
+		}
+
+		tc.fail(e, s)
+		testData(e, 3, 4, 5, 6)
+		s.sendAck()
+		if acks := testAcks(e); len(acks) != 0 {
+			t.Errorf("%s: acks %v after the failure", tc.name, acks)
+		}
+		if !s.WriteFailed || s.DurableSeq != 1 || s.AckedSeq != 1 {
+			t.Errorf("%s: write failed %v, durable seq %d, acked seq %d, want true, 1, 1",
+				tc.name, s.WriteFailed, s.DurableSeq, s.AckedSeq)
+		}
+		e.SessionMgrReset()
+	}
+}
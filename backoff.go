@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential backoff with jitter used between reconnect attempts.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+	current    time.Duration
+}
+
+func NewBackoff(initial time.Duration, max time.Duration, multiplier float64, jitter float64) *Backoff {
+	return &Backoff{
+		Initial:    initial,
+		Max:        max,
+		Multiplier: multiplier,
+		Jitter:     jitter,
+	}
+}
+
+// Next returns the delay before the next attempt and grows the interval.
+func (b *Backoff) Next() time.Duration {
+	if b.current == 0 {
+		b.current = b.Initial
+	}
+	d := b.current
+
+	next := time.Duration(float64(b.current) * b.Multiplier)
+	if next > b.Max {
+		next = b.Max
+	}
+	b.current = next
+
+	if b.Jitter > 0 {
+		delta := float64(d) * b.Jitter
+		d = time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+func (b *Backoff) Reset() {
+	b.current = 0
+}
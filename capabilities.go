@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+)
+
+// IPDR/SP capability bits of CONNECT and CONNECT_RESPONSE.
+type Capabilities uint32
+
+const (
+	CAP_STRUCTURES           Capabilities = 0x01
+	CAP_MULTISESSION         Capabilities = 0x02
+	CAP_TEMPLATE_NEGOTIATION Capabilities = 0x04
+)
+
+var capabilityName map[Capabilities]string = map[Capabilities]string{
+	CAP_STRUCTURES:           "structures",
+	CAP_MULTISESSION:         "multisession",
+	CAP_TEMPLATE_NEGOTIATION: "template-negotiation",
+}
+
+func (c Capabilities) Has(f Capabilities) bool {
+	return c&f == f
+}
+
+func (c Capabilities) String() string {
+	names := []string{}
+	for _, f := range []Capabilities{CAP_STRUCTURES, CAP_MULTISESSION, CAP_TEMPLATE_NEGOTIATION} {
+		if c.Has(f) {
+			names = append(names, capabilityName[f])
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Only what both sides support is used on the connection.
+func (e *Exporter) SetCapabilities(peer Capabilities) {
+	e.capabilities = e.advertisedCaps & peer
+	e.logf("Capabilities advertised: %s, exporter: %s, agreed: %s\n",
+		e.advertisedCaps, peer, e.capabilities)
+}
+
+func (e *Exporter) HasCapability(f Capabilities) bool {
+	return e.capabilities.Has(f)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Last acked position of a session document, kept across restarts in
+// IPDR_STATE_<exporter>.json under the state dir.
+type SessionCheckpoint struct {
+	DocID    string    `json:"doc-id"`
+	LastSeq  uint64    `json:"last-seq"`
+	ConfigId uint16    `json:"config-id"`
+	Time     time.Time `json:"time"`
+}
+
+func (e *Exporter) checkpointFileName() string {
+	return filepath.Join(GetStateDir(), fmt.Sprintf("IPDR_STATE_%s.json", fileNameSafe(e.Name)))
+}
+
+func (e *Exporter) LoadCheckpoints() error {
+	e.checkpointMutex.Lock()
+	defer e.checkpointMutex.Unlock()
+
+	b, err := ioutil.ReadFile(e.checkpointFileName())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	saved := map[string]*SessionCheckpoint{}
+	if err = json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	for key, cp := range saved {
+		id, err := strconv.ParseUint(key, 10, 8)
+		if err != nil {
+			return fmt.Errorf("bad session id %q", key)
+		}
+		e.checkpoints[byte(id)] = cp
+		e.logf("Checkpoint sess %d doc %s seq %d\n", id, cp.DocID, cp.LastSeq)
+	}
+	return nil
+}
+
+// Must hold checkpointMutex. Through a temp file so a crash leaves either
+// the old or the new state.
+func (e *Exporter) saveCheckpoints() error {
+	saved := map[string]*SessionCheckpoint{}
+	for id, cp := range e.checkpoints {
+		saved[strconv.Itoa(int(id))] = cp
+	}
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fileName := e.checkpointFileName()
+	tmpName := fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(b); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+	if err != nil {
+		// The previous state stays in place.
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+func (e *Exporter) getCheckpoint(sessId byte) *SessionCheckpoint {
+	e.checkpointMutex.Lock()
+	defer e.checkpointMutex.Unlock()
+
+	return e.checkpoints[sessId]
+}
+
+// Record the acked position of a session.
+func (s *Session) Checkpoint() {
+	e := s.exporter
+	e.checkpointMutex.Lock()
+	defer e.checkpointMutex.Unlock()
+
+	docId := hex.EncodeToString(s.DocID)
+	// Records replayed below the checkpoint do not move it back.
+	if cp, ok := e.checkpoints[s.Id]; ok && cp.DocID == docId && s.AckedSeq <= cp.LastSeq {
+		return
+	}
+	e.checkpoints[s.Id] = &SessionCheckpoint{
+		DocID:    docId,
+		LastSeq:  s.AckedSeq,
+		ConfigId: s.ConfigId,
+		Time:     time.Now(),
+	}
+	if err := e.saveCheckpoints(); err != nil {
+		e.logf("Save checkpoint of sess %d: %s\n", s.Id, err)
+	}
+}
+
+// Compare SESSION_START with the checkpoint, a document already partly
+// stored is resumed and the records up to the checkpoint are skipped.
+func (s *Session) resumeFromCheckpoint(m *SessionStart) {
+	s.Resuming = false
+	s.ResumeSeq = 0
+
+	cp := s.exporter.getCheckpoint(s.Id)
+	if cp == nil {
+		return
+	}
+	docId, err := hex.DecodeString(cp.DocID)
+	if err != nil || !bytes.Equal(docId, m.DocumentID) {
+		s.exporter.logf("Sess %d new doc %x, checkpoint doc %s seq %d\n",
+			s.Id, m.DocumentID, cp.DocID, cp.LastSeq)
+		return
+	}
+
+	switch {
+	case m.FirstRecordSeqNum > cp.LastSeq+1:
+		s.exporter.logf("Sess %d resume doc %x at seq %d, %d records after checkpoint %d lost\n",
+			s.Id, m.DocumentID, m.FirstRecordSeqNum, m.FirstRecordSeqNum-cp.LastSeq-1, cp.LastSeq)
+	case m.FirstRecordSeqNum <= cp.LastSeq:
+		s.Resuming = true
+		s.ResumeSeq = cp.LastSeq
+		s.exporter.logf("Sess %d resume doc %x at seq %d, skip records up to checkpoint %d\n",
+			s.Id, m.DocumentID, m.FirstRecordSeqNum, cp.LastSeq)
+	default:
+		s.exporter.logf("Sess %d resume doc %x at seq %d after checkpoint\n",
+			s.Id, m.DocumentID, m.FirstRecordSeqNum)
+	}
+}
@@ -11,21 +11,83 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
+// A single TCP connection to the exporter. It is torn down once, by whichever
+// routine first notices the failure.
+type Connection struct {
+	conn    net.Conn
+	rcvChan chan []byte
+	done    chan struct{}
+	once    sync.Once
+}
+
 var (
-	sendChan      = make(chan []byte, 1)
-	rcvChan       = make(chan []byte, 10)
-	run      bool = true
+	exporters []*Exporter
+	run       bool = true
 )
 
+func NewConnection(conn net.Conn) *Connection {
+	return &Connection{
+		conn:    conn,
+		rcvChan: make(chan []byte, 10),
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *Connection) Close(reason error) {
+	c.once.Do(func() {
+		log.Printf("Close connection to %s: %s\n", c.conn.RemoteAddr(), reason)
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (e *Exporter) setActiveConn(c *Connection) {
+	e.connMutex.Lock()
+	defer e.connMutex.Unlock()
+	e.activeConn = c
+}
+
+func (e *Exporter) getActiveConn() *Connection {
+	e.connMutex.Lock()
+	defer e.connMutex.Unlock()
+	return e.activeConn
+}
+
+// Exporter asked to back off, the next reconnect waits the longest delay.
+func (e *Exporter) setHoldOff() {
+	e.connMutex.Lock()
+	defer e.connMutex.Unlock()
+	e.holdOff = true
+}
+
+func (e *Exporter) takeHoldOff() bool {
+	e.connMutex.Lock()
+	defer e.connMutex.Unlock()
+	holdOff := e.holdOff
+	e.holdOff = false
+	return holdOff
+}
+
+func (e *Exporter) IsConnected() bool {
+	return e.getActiveConn() != nil
+}
+
+// Tear down the current connection, the reconnect loop will take over.
+func (e *Exporter) CloseConnection(reason error) {
+	if c := e.getActiveConn(); c != nil {
+		c.Close(reason)
+	}
+}
+
 func msgSanityCheck(msg []byte) error {
 
 	if (len(msg)) < 8 {
-		log.Printf("in sanity check, msg is not correct\n")
-		return nil
+		return errors.New(fmt.Sprintf("Msg too short! (msg len: %d)", len(msg)))
 	}
 
 	if msg[0] != 2 {
@@ -47,7 +109,13 @@ func msgDecode(msg []byte) (IPDRMsg, error) {
 	var rcvdMsg IPDRMsg
 	var err error
 
+	if len(msg) < 8 {
+		return nil, &DecodeError{Err: checkMsgLen(msg, 8, "header")}
+	}
+
 	switch MessageID(msg[1]) {
+	case CONNECT:
+		rcvdMsg = &Connect{}
 	case CONNECT_RESPONSE:
 		rcvdMsg = &ConnectResponse{}
 	case TEMPLATE_DATA:
@@ -64,61 +132,95 @@ func msgDecode(msg []byte) (IPDRMsg, error) {
 		rcvdMsg = &Error{}
 	case GET_SESSIONS_RESPONSE:
 		rcvdMsg = &GetSessionsResponse{}
+	case DISCONNECT:
+		rcvdMsg = &Disconnect{}
+	case MODIFY_TEMPLATE_RESPONSE:
+		rcvdMsg = &ModifyTemplateResponse{}
+	case START_NEGOTIATION_REJECT:
+		rcvdMsg = &StartNegotiationReject{}
+	case GET_TEMPLATES_RESPONSE:
+		rcvdMsg = &GetTemplatesResponse{}
 	default:
 		log.Printf("Unsupported msg 0x%x\n", msg[1])
-		return nil, errors.New("Unsupported msg.")
+		return nil, ErrMsgUnsupported
 	}
 
 	err = rcvdMsg.Decode(msg)
-	return rcvdMsg, err
+	if err != nil {
+		return nil, &DecodeError{MsgId: MessageID(msg[1]), Err: err}
+	}
+	return rcvdMsg, nil
 }
 
-func handleRcvMsg(msg []byte) ([]IPDRMsg, error) {
+func (e *Exporter) handleRcvMsg(c *Connection, msg []byte) error {
 	rcvdMsg, err := msgDecode(msg)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	log.Printf("Rcvd %s\n", rcvdMsg.Desc())
-	RcvMsg(rcvdMsg)
-	UpdateLastKaRcvdTime()
-	return rcvdMsg.RespMsg(), nil
+	e.logf("Rcvd %s\n", rcvdMsg.Desc())
+	// Responses are sent by the session mgr once the msg is valid for state.
+	e.RcvMsg(c, rcvdMsg)
+	e.UpdateLastKaRcvdTime()
+	return nil
 }
 
-func receiveMsg(msg []byte) {
+func (e *Exporter) receiveMsg(c *Connection, msg []byte) {
+	// A bad msg only takes down the connection of this exporter.
+	defer func() {
+		if r := recover(); r != nil {
+			e.logf("Panic when handle msg 0x%x: %v\n", msg[1], r)
+			c.Close(fmt.Errorf("handle msg 0x%x panic: %v", msg[1], r))
+		}
+	}()
+
 	err := msgSanityCheck(msg)
 
 	if err != nil {
-		log.Fatalf("Msg Error: %s", err)
+		e.logf("Msg Error: %s", err)
+		c.Close(err)
 		return
 	}
-	nextMsgs, err := handleRcvMsg(msg)
-	if nextMsgs != nil {
-		for _, nextMsg := range nextMsgs {
-			log.Printf("Send %s\n", nextMsg.Desc())
-			sendChan <- nextMsg.Encode()
-		}
+	err = e.handleRcvMsg(c, msg)
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		// Framing is still intact, report it and go on with the next msg.
+		e.logf("Msg Error: %s\n", err)
+		errMsg := NewErrorMsg(ERR_MSG_DECODE_ERROR, "")
+		e.logf("Send %s\n", errMsg.Desc())
+		e.sendChan <- errMsg.Encode()
 	}
 }
 
-func sendMsg(conn net.Conn, msg []byte) {
-	_, err := conn.Write(msg)
+func (e *Exporter) sendMsg(c *Connection, msg []byte) {
+	_, err := c.conn.Write(msg)
 
-	UpdateLastKaSendTime()
+	e.UpdateLastKaSendTime()
 
 	if err != nil {
-		log.Fatal("Send msg error:", err)
+		e.logf("Send msg error: %s\n", err)
+		c.Close(err)
+	}
+}
+
+// Write the msg synchronously, then tear down the connection.
+func (e *Exporter) SendAndCloseConnection(msg []byte, reason error) {
+	if c := e.getActiveConn(); c != nil {
+		e.sendMsg(c, msg)
+		c.Close(reason)
 	}
 }
 
-func RcvMsgHandlerRoutine() {
+func (e *Exporter) RcvMsgHandlerRoutine(c *Connection) {
 	var buf_remain []byte
 	var buf_len_remain uint32
 	var msg_len uint32
 	i := 0
 	for {
 		select {
-		case buf := <-rcvChan:
+		case <-c.done:
+			return
+		case buf := <-c.rcvChan:
 			buf_remain = append(buf_remain, buf...)
 			//log.Printf("Handle msg %d, len %d, remain buf len %d\n", i, len(buf), len(buf_remain))
 			i++
@@ -128,10 +230,27 @@ func RcvMsgHandlerRoutine() {
 					break
 				}
 				msg_len = binary.BigEndian.Uint32(buf_remain[4:8])
+				if msg_len < 8 {
+					c.Close(errors.New(fmt.Sprintf("Msg length error! (in msg: %d)", msg_len)))
+					return
+				}
+				if msg_len > MAX_MSG_LEN {
+					errMsg := NewErrorMsg(ERR_MSG_DECODE_ERROR, "")
+					e.logf("Send %s\n", errMsg.Desc())
+					e.sendMsg(c, errMsg.Encode())
+					c.Close(fmt.Errorf("msg length %d over %d: %w", msg_len, MAX_MSG_LEN, ErrMsgTruncated))
+					return
+				}
 				if buf_len_remain < msg_len {
 					break
 				}
-				receiveMsg(buf_remain[0:msg_len])
+				// The rest of the buf is of a closed connection.
+				select {
+				case <-c.done:
+					return
+				default:
+				}
+				e.receiveMsg(c, buf_remain[0:msg_len])
 				buf_remain = buf_remain[msg_len:]
 				buf_len_remain = uint32(len(buf_remain))
 			}
@@ -139,39 +258,52 @@ func RcvMsgHandlerRoutine() {
 	}
 }
 
-func ReceiverRoutine(conn net.Conn) {
+func (e *Exporter) ReceiverRoutine(c *Connection) {
 	i := 0
 	for {
 		if !run {
 			break
 		}
 		buf := make([]byte, 65536)
-		cnt, err := conn.Read(buf)
+		cnt, err := c.conn.Read(buf)
 		if err == io.EOF {
-			log.Printf("EOF Error!")
+			e.logf("EOF Error!")
+			c.Close(err)
 			break
 		}
 		if err != nil {
-			log.Printf("Failed to read data from net: %s\n", err)
-			continue
+			e.logf("Failed to read data from net: %s\n", err)
+			c.Close(err)
+			break
 		}
 		//log.Printf("Rcv buf %d, len %d\n", i, cnt)
 		i++
-		rcvChan <- buf[:cnt]
+		select {
+		case c.rcvChan <- buf[:cnt]:
+		case <-c.done:
+			return
+		}
 	}
 }
 
-func SenderRoutine(conn net.Conn) {
+// Messages are written to whatever connection is active when they are
+// dequeued, anything queued while disconnected is dropped.
+func (e *Exporter) SenderRoutine() {
 	for {
 		select {
-		case buf := <-sendChan:
-			go sendMsg(conn, buf)
+		case buf := <-e.sendChan:
+			c := e.getActiveConn()
+			if c == nil {
+				e.logf("Not connected, drop msg 0x%x\n", buf[1])
+				continue
+			}
+			e.sendMsg(c, buf)
 		}
 	}
 }
 
-func SendMsgToExporter(buf []byte) {
-	sendChan <- buf
+func (e *Exporter) SendMsgToExporter(buf []byte) {
+	e.sendChan <- buf
 }
 
 func convertToIntIP(ip string) (uint32, error) {
@@ -191,7 +323,7 @@ func convertToIntIP(ip string) (uint32, error) {
 	return intIP, nil
 }
 
-func StartCollector(address string, port uint16, clientName string, version uint8, ka uint32) {
+func (e *Exporter) StartCollector(address string, port uint16, clientName string, version uint8, ka uint32) error {
 
 	var data []byte = []byte(clientName)
 	var h MsgHdr = MsgHdr{
@@ -207,61 +339,195 @@ func StartCollector(address string, port uint16, clientName string, version uint
 
 	initAddr, err := convertToIntIP(address)
 	if err != nil {
-		log.Fatalf("Convert to Int IP error: %v\n", err)
-		return
+		e.logf("Convert to Int IP error: %v\n", err)
+		return err
 	}
 
-	SetKaRecvInterval(ka)
+	e.SetKaRecvInterval(ka)
+	if IsPassiveMode() {
+		// The exporter opened the connection, it sends the CONNECT.
+		return nil
+	}
 	connect := &Connect{
 		Header:       h,
 		InitAddr:     initAddr,
 		InitPort:     port,
-		Capabilities: 2,
+		Capabilities: e.advertisedCaps,
 		KaInterval:   ka,
 		VendorId:     vId,
 	}
 
-	log.Printf("Send %s\n", connect.Desc())
+	e.logf("Send %s\n", connect.Desc())
 
-	sendChan <- connect.Encode()
+	e.sendChan <- connect.Encode()
+	return nil
 }
 
-func main() {
-	err := ReadConfig()
+// Run the CONNECT / GET_SESSIONS / FLOW_START sequence on an established
+// connection and block until it is torn down.
+func (e *Exporter) RunConnection(c *Connection) {
+	e.resetSessionMgr()
+	e.setActiveConn(c)
+
+	go e.RcvMsgHandlerRoutine(c)
+	go e.ReceiverRoutine(c)
+
+	err := e.StartCollector(e.config.GetConnectParam())
 	if err != nil {
-		log.Fatalf("Read config file error: %v\n", err)
-		return
+		c.Close(err)
+	}
+
+	<-c.done
+	e.setActiveConn(nil)
+	e.SetConnState(CONN_STOPPED)
+}
+
+// Dial the exporter, run the connection until it is lost, then retry with
+// exponential backoff.
+func (e *Exporter) ConnectRoutine() {
+	server := e.config.GetServerAddr()
+	timeout := e.config.GetConnectTimeout()
+	backoff := NewBackoff(e.config.GetReconnectParam())
+
+	for run {
+		conn, err := net.DialTimeout("tcp", server, time.Second*time.Duration(timeout))
+		if err != nil {
+			delay := backoff.Next()
+			e.logf("Fail to connect %s, %s, retry in %v\n", server, err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		e.logf("Connected to %s\n", server)
+
+		connectedAt := time.Now()
+		e.RunConnection(NewConnection(conn))
+
+		if !run {
+			break
+		}
+		// A connection which stayed up for a while is not a flapping one.
+		if time.Since(connectedAt) >= backoff.Max {
+			backoff.Reset()
+		}
+		delay := backoff.Next()
+		if e.takeHoldOff() {
+			// Exporter is terminating, don't hurry back.
+			delay = backoff.Max
+		}
+		e.logf("Connection to %s lost, reconnect in %v\n", server, delay)
+		time.Sleep(delay)
 	}
+}
 
-	server := GetServerAddr()
-	timeout := GetConnectTimeout()
+// A new connection from the exporter replaces the current one, the exporter
+// only streams to one.
+func (e *Exporter) AcceptConnection(conn net.Conn) {
+	e.acceptLock.Lock()
+	if e.prevConn != nil {
+		e.prevConn.Close(errors.New("superseded by new connection"))
+		<-e.prevDone
+	}
+	c := NewConnection(conn)
+	done := make(chan struct{})
+	e.prevConn = c
+	e.prevDone = done
+	e.acceptLock.Unlock()
+
+	e.RunConnection(c)
+	close(done)
+}
 
-	conn, err := net.DialTimeout("tcp", server, time.Second*time.Duration(timeout))
+// Passive mode, wait for the exporters to open the connections.
+func ListenRoutine() {
+	addr := GetListenAddr()
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("Fail to connect, %s\n", err)
+		log.Fatalf("Fail to listen on %s, %s\n", addr, err)
 		return
 	}
-	defer conn.Close()
+	defer ln.Close()
+	log.Printf("Listen on %s\n", addr)
 
-	SessionMgrInit()
+	for run {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("Fail to accept, %s\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
 
-	go RcvMsgHandlerRoutine()
-	go ReceiverRoutine(conn)
-	go SenderRoutine(conn)
+		var e *Exporter
+		if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			e = FindExporterByAddr(tcpAddr.IP)
+		}
+		if e == nil {
+			log.Printf("Reject connection from unknown exporter %s\n", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		e.logf("Accepted connection from %s\n", conn.RemoteAddr())
 
-	StartCollector(GetConnectParam())
+		go e.AcceptConnection(conn)
+	}
+}
+
+func main() {
+	err := ReadConfig()
+	if err != nil {
+		log.Fatalf("Read config file error: %v\n", err)
+		return
+	}
+	if err = RegisterConfigTypes(); err != nil {
+		log.Fatalf("Config types error: %v\n", err)
+		return
+	}
+	if dir := config.Collector.SchemaDir; dir != "" {
+		if schemaCatalog, err = LoadSchemas(dir); err != nil {
+			log.Fatalf("Load schemas error: %v\n", err)
+			return
+		}
+		log.Printf("Loaded %d schema types from %s\n", schemaCatalog.NumTypes(), dir)
+	}
+
+	for _, cfg := range GetExporters() {
+		exporters = append(exporters, NewExporter(cfg))
+	}
+	for _, e := range exporters {
+		e.Start()
+	}
+	if IsPassiveMode() {
+		go ListenRoutine()
+	}
 
 	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
 	run = true
 	for run == true {
 		select {
 		case sig := <-sigchan:
+			if sig == syscall.SIGUSR1 {
+				// Operator asks for the exporter templates.
+				for _, e := range exporters {
+					e.RequestTemplates()
+				}
+				continue
+			}
 			log.Printf("Caught signal %v: terminating\n", sig)
 			run = false
 		}
 	}
 
+	var wg sync.WaitGroup
+	timeout := GetDrainTimeout()
+	for _, e := range exporters {
+		wg.Add(1)
+		go func(e *Exporter) {
+			defer wg.Done()
+			e.Shutdown(timeout)
+		}(e)
+	}
+	wg.Wait()
+
 	log.Print("Exiting collector")
 
 }
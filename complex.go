@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Complex types of IPDR v2, sent when the structures capability is agreed.
+// A structure type carries the id of a structure defined in TEMPLATE_DATA,
+// the array flag marks an array of a basic or structure type. On the wire
+// a structure is its fields in order, an array a count then its elements.
+const (
+	TYPE_STRUCTURE_FLAG TypeID = 0x40000000
+	TYPE_ARRAY_FLAG     TypeID = 0x80000000
+)
+
+func (t TypeID) IsArray() bool {
+	return t&TYPE_ARRAY_FLAG != 0
+}
+
+func (t TypeID) IsStructure() bool {
+	return t&TYPE_ARRAY_FLAG == 0 && t&TYPE_STRUCTURE_FLAG != 0
+}
+
+func (t TypeID) IsComplex() bool {
+	return t&(TYPE_ARRAY_FLAG|TYPE_STRUCTURE_FLAG) != 0
+}
+
+// Type of the elements of an array type.
+func (t TypeID) ElemType() TypeID {
+	return t &^ TYPE_ARRAY_FLAG
+}
+
+func (t TypeID) StructureID() uint16 {
+	return uint16(t)
+}
+
+type Structure struct {
+	Id     uint16
+	Name   string
+	Fields []*Field
+}
+
+// Structure definitions of a session, by id.
+type Structures map[uint16]*Structure
+
+func newStructures(blocks []TemplateBlock) Structures {
+	structs := make(Structures)
+	for _, tb := range blocks {
+		t := newTemplate(tb)
+		structs[t.TemplateID] = &Structure{
+			Id:     t.TemplateID,
+			Name:   t.TypeName,
+			Fields: t.Fields,
+		}
+	}
+	return structs
+}
+
+// How complex fields become CSV columns, by the collector "flatten".
+type FlattenPolicy string
+
+const (
+	// One column holding the value as JSON, the default.
+	FLATTEN_JSON FlattenPolicy = "json"
+	// A column per structure member, "field.member". Arrays stay JSON.
+	FLATTEN_COLUMNS FlattenPolicy = "columns"
+)
+
+// Structure nesting deeper than this is taken as a loop in the definitions.
+const maxStructureDepth = 16
+
+var errStructureDepth = errors.New("structure nesting too deep")
+
+func isNumericType(t TypeID) bool {
+	if def := lookupType(t); def != nil {
+		return def.Numeric
+	}
+	switch t {
+	case INT, UINT, LONG, ULONG, FLOAT, DOUBLE, BOOLEAN, BYTE, UBYTE, SHORT, USHORT,
+		DATETIME, DATETIMEMSEC, DATETIMEUSEC:
+		return true
+	}
+	return false
+}
+
+// Length of a basic field, checked against the bytes left.
+func xdrBasicLength(typeID TypeID, input []byte) (uint32, error) {
+	if def := lookupType(typeID); def != nil {
+		length, err := def.Length(input)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", typeID, err)
+		}
+		if uint64(length) > uint64(len(input)) {
+			return 0, fmt.Errorf("%s: %w", typeID, ErrMsgTruncated)
+		}
+		return length, nil
+	}
+	switch typeID {
+	case HEXBINARY, STRING, IPADDR:
+		length, err := xdrOpaqueLength(input)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", typeID, err)
+		}
+		return length, nil
+	}
+	length := XdrTypeLength(typeID, input)
+	if length == 0 {
+		return 0, fmt.Errorf("unknown type %s", typeID)
+	}
+	if uint64(length) > uint64(len(input)) {
+		return 0, fmt.Errorf("%s: %w", typeID, ErrMsgTruncated)
+	}
+	return length, nil
+}
+
+// CSV quoting of a value with commas or quotes, as complex values have.
+func csvQuote(str string) string {
+	if !strings.ContainsAny(str, ",\"\n") {
+		return str
+	}
+	return "\"" + strings.Replace(str, "\"", "\"\"", -1) + "\""
+}
+
+// CSV header columns of a field, matching formatColumns.
+func headerColumns(name string, typeID TypeID, structs Structures, depth int) []string {
+	if !typeID.IsStructure() || GetFlattenPolicy() != FLATTEN_COLUMNS || depth > maxStructureDepth {
+		return []string{name}
+	}
+	st, ok := structs[typeID.StructureID()]
+	if !ok {
+		return []string{name}
+	}
+	cols := []string{}
+	for _, f := range st.Fields {
+		cols = append(cols, headerColumns(name+"."+f.FieldName, TypeID(f.TypeID), structs, depth+1)...)
+	}
+	return cols
+}
@@ -2,38 +2,92 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
+	"strings"
+	"time"
 )
 
 var config Config
 
 type ConfigCollector struct {
-	Address     string `json:"address"`
-	Port        uint16 `json:"port"`
-	Vendor      string `json:"vendor"`
-	Version     uint8  `json:"version"`
-	Negotiation bool   `json:"negotiation"`
+	Address      string `json:"address"`
+	Port         uint16 `json:"port"`
+	Vendor       string `json:"vendor"`
+	Version      uint8  `json:"version"`
+	Negotiation  bool   `json:"negotiation"`
+	Structures   bool   `json:"structures"`
+	StateDir     string `json:"state-dir"`
+	AckPolicy    string `json:"ack-policy"`
+	Mismatch     string `json:"config-mismatch"`
+	Flatten      string `json:"flatten"`
+	Mode         string `json:"mode"`
+	DrainTimeout uint32 `json:"drain-timeout"`
+	SchemaDir    string `json:"schema-dir"`
+
+	// Vendor types, registered at start.
+	Types []ConfigType `json:"types"`
+}
+
+// Fields to enable / disable by template negotiation, template is matched by
+// id or type name.
+type ConfigTemplate struct {
+	Id       uint16   `json:"id"`
+	TypeName string   `json:"type-name"`
+	Enable   []string `json:"enable"`
+	Disable  []string `json:"disable"`
+	// Enumerated fields as "raw" value, "name" or "both".
+	Enums string `json:"enums"`
+}
+
+// Replayed records are dropped when found in the window of recently seen
+// (DocumentID, sequence) keys. Policy handles duplicate flagged records
+// not in the window: "drop", "separate" file or "mark" with a column.
+type ConfigDedup struct {
+	Policy  string `json:"policy"`
+	Window  int    `json:"window"`
+	Persist bool   `json:"persist"`
 }
 
+// Sessions to start from the GET_SESSIONS_RESPONSE list. A non-zero id
+// selects by id, otherwise by exact name, or by a glob pattern on the
+// session name and description, e.g. "*SAMIS*".
 type ConfigSession struct {
-	Id   byte   `json:"id"`
-	Name string `json:"name"`
+	Id        byte             `json:"id"`
+	Name      string           `json:"name"`
+	Pattern   string           `json:"pattern"`
+	Type      string           `json:"type"`
+	Templates []ConfigTemplate `json:"templates"`
+	Dedup     ConfigDedup      `json:"dedup"`
+}
+
+type ConfigReconnect struct {
+	InitialInterval uint32  `json:"initial-interval"`
+	MaxInterval     uint32  `json:"max-interval"`
+	Multiplier      float64 `json:"multiplier"`
+	Jitter          float64 `json:"jitter"`
 }
 
 type ConfigExporter struct {
+	Name           string          `json:"name"`
 	Address        string          `json:"address"`
 	Port           uint16          `json:"port"`
 	KeepAlive      uint32          `json:"keep-alive"`
 	ConnectTimeout uint32          `json:"connect-timeout"`
+	Reconnect      ConfigReconnect `json:"reconnect"`
+	GetTemplates   bool            `json:"get-templates"`
 	Sessions       []ConfigSession `json:"sessions"`
 }
 
 type Config struct {
-	Collector ConfigCollector `json:"collector"`
-	Exporter  ConfigExporter  `json:"exporter"`
+	Collector ConfigCollector  `json:"collector"`
+	Exporters []ConfigExporter `json:"exporters"`
+	// Single exporter form of old config files.
+	Exporter *ConfigExporter `json:"exporter"`
 }
 
 func ReadConfig() error {
@@ -55,27 +109,190 @@ func ReadConfig() error {
 		return err
 	}
 
+	if config.Exporter != nil {
+		config.Exporters = append(config.Exporters, *config.Exporter)
+		config.Exporter = nil
+	}
+
+	if len(config.Exporters) == 0 {
+		return errors.New("No exporter configured")
+	}
+
 	return nil
 }
 
-func GetServerAddr() string {
+func GetExporters() []*ConfigExporter {
+	exps := []*ConfigExporter{}
+	for i := range config.Exporters {
+		exps = append(exps, &config.Exporters[i])
+	}
+
+	return exps
+}
+
+// In passive mode the exporter connects to the collector address and sends
+// the CONNECT, the collector answers with CONNECT_RESPONSE.
+func IsPassiveMode() bool {
+	return config.Collector.Mode == "passive"
+}
+
+// Time allowed for the final DATA_ACK / FLOW_STOP / DISCONNECT on shutdown.
+func GetDrainTimeout() time.Duration {
+	if config.Collector.DrainTimeout == 0 {
+		return 5 * time.Second
+	}
+	return time.Second * time.Duration(config.Collector.DrainTimeout)
+}
 
-	return fmt.Sprintf("%s:%d", config.Exporter.Address, config.Exporter.Port)
+// Capabilities advertised in CONNECT, multisession is always supported.
+func GetCapabilities() Capabilities {
+	caps := CAP_MULTISESSION
+	if config.Collector.Negotiation {
+		caps |= CAP_TEMPLATE_NEGOTIATION
+	}
+	if config.Collector.Structures {
+		caps |= CAP_STRUCTURES
+	}
+	return caps
 }
 
-func GetConnectTimeout() uint32 {
-	return config.Exporter.ConnectTimeout
+// Directory of the files kept across restarts.
+func GetStateDir() string {
+	if config.Collector.StateDir == "" {
+		return "."
+	}
+	return config.Collector.StateDir
 }
 
-func GetConnectParam() (string, uint16, string, uint8, uint32) {
-	return config.Collector.Address, config.Collector.Port, config.Collector.Vendor, config.Collector.Version, config.Exporter.KeepAlive
+func GetAckPolicy() AckPolicy {
+	if AckPolicy(config.Collector.AckPolicy) == ACK_RECEIVED {
+		return ACK_RECEIVED
+	}
+	return ACK_DURABLE
 }
 
-func GetSessionList() []byte {
-	sessIds := []byte{}
-	for _, s := range config.Exporter.Sessions {
-		sessIds = append(sessIds, s.Id)
+func GetMismatchPolicy() MismatchPolicy {
+	if MismatchPolicy(config.Collector.Mismatch) == MISMATCH_REJECT {
+		return MISMATCH_REJECT
 	}
+	return MISMATCH_QUARANTINE
+}
 
-	return sessIds
+func GetFlattenPolicy() FlattenPolicy {
+	if FlattenPolicy(config.Collector.Flatten) == FLATTEN_COLUMNS {
+		return FLATTEN_COLUMNS
+	}
+	return FLATTEN_JSON
+}
+
+func (t *ConfigTemplate) GetEnumMode() EnumMode {
+	switch EnumMode(t.Enums) {
+	case ENUM_NAME, ENUM_BOTH:
+		return EnumMode(t.Enums)
+	}
+	return ENUM_RAW
+}
+
+func (d *ConfigDedup) GetPolicy() DedupPolicy {
+	switch DedupPolicy(d.Policy) {
+	case DEDUP_DROP, DEDUP_SEPARATE, DEDUP_MARK:
+		return DedupPolicy(d.Policy)
+	}
+	return DEDUP_SEPARATE
+}
+
+func (d *ConfigDedup) GetWindow() int {
+	if d.Window <= 0 {
+		return 100000
+	}
+	return d.Window
+}
+
+func GetListenAddr() string {
+
+	return fmt.Sprintf("%s:%d", config.Collector.Address, config.Collector.Port)
+}
+
+// Exporter name used in logs and output files, address if not configured.
+func (e *ConfigExporter) GetName() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Address
+}
+
+func (e *ConfigExporter) GetServerAddr() string {
+
+	return fmt.Sprintf("%s:%d", e.Address, e.Port)
+}
+
+func (e *ConfigExporter) GetConnectTimeout() uint32 {
+	return e.ConnectTimeout
+}
+
+// Reconnect backoff, interval in seconds. Unset values fall back to defaults.
+func (e *ConfigExporter) GetReconnectParam() (time.Duration, time.Duration, float64, float64) {
+	r := e.Reconnect
+	initial := r.InitialInterval
+	if initial == 0 {
+		initial = 1
+	}
+	max := r.MaxInterval
+	if max == 0 {
+		max = 60
+	}
+	if max < initial {
+		max = initial
+	}
+	multiplier := r.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	jitter := r.Jitter
+	if jitter < 0 || jitter > 1 {
+		jitter = 0.2
+	}
+	return time.Second * time.Duration(initial), time.Second * time.Duration(max), multiplier, jitter
+}
+
+func (e *ConfigExporter) GetConnectParam() (string, uint16, string, uint8, uint32) {
+	return config.Collector.Address, config.Collector.Port, config.Collector.Vendor, config.Collector.Version, e.KeepAlive
+}
+
+func (s *ConfigSession) GetTemplateConfig(templateId uint16, typeName string) *ConfigTemplate {
+	for i := range s.Templates {
+		t := &s.Templates[i]
+		if (t.Id != 0 && t.Id == templateId) || (t.TypeName != "" && t.TypeName == typeName) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *ConfigSession) Match(b *SessionBlock) bool {
+	if s.Id != 0 {
+		return s.Id == b.SessId
+	}
+	if s.Name != "" && s.Pattern == "" {
+		return s.Name == string(b.SessName.Str)
+	}
+	if s.Pattern != "" {
+		pattern := strings.ToLower(s.Pattern)
+		for _, str := range []UTF8String{b.SessName, b.SessDesc} {
+			if ok, _ := path.Match(pattern, strings.ToLower(string(str.Str))); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *ConfigSession) String() string {
+	if s.Id != 0 {
+		return fmt.Sprintf("id %d (%s)", s.Id, s.Name)
+	}
+	if s.Pattern != "" {
+		return fmt.Sprintf("pattern \"%s\"", s.Pattern)
+	}
+	return fmt.Sprintf("name \"%s\"", s.Name)
 }
@@ -3,15 +3,13 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
-	"fmt"
 )
 
 type Connect struct {
 	Header       MsgHdr
 	InitAddr     uint32
 	InitPort     uint16
-	Capabilities uint32
+	Capabilities Capabilities
 	KaInterval   uint32
 	VendorId     UTF8String
 }
@@ -51,16 +49,32 @@ func (m *Connect) Encode() []byte {
 	return b
 }
 
+// Sent by the exporter on a connection it opened, passive mode.
 func (m *Connect) Decode(msg []byte) error {
 
-	return errors.New(fmt.Sprintf("Not support decode for %s", m.Desc()))
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 22, "keepalive interval"); err != nil {
+		return err
+	}
+	m.InitAddr = binary.BigEndian.Uint32(msg[8:12])
+	m.InitPort = binary.BigEndian.Uint16(msg[12:14])
+	m.Capabilities = Capabilities(binary.BigEndian.Uint32(msg[14:18]))
+	m.KaInterval = binary.BigEndian.Uint32(msg[18:22])
+	m.VendorId, _, err = decodeUTF8Field(msg[22:], "vendor id")
+
+	return err
 }
 
 func (m *Connect) Desc() string {
 	return "CONNECT"
 }
 
+// The CONNECT_RESPONSE is sent by the session mgr, then the same as after
+// our own CONNECT.
 func (m *Connect) RespMsg() []IPDRMsg {
 
-	return nil
+	return (&ConnectResponse{}).RespMsg()
 }
@@ -3,31 +3,54 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"log"
 )
 
 type ConnectResponse struct {
 	Header       MsgHdr
-	Capabilities uint32
+	Capabilities Capabilities
 	KaInterval   uint32
 	VendorId     UTF8String
 }
 
 func (m *ConnectResponse) Encode() []byte {
-	log.Printf("Not support to Encode!\n")
-	return nil
+
+	b := []byte{}
+	bytesBuffer := bytes.NewBuffer([]byte{})
+
+	binary.Write(bytesBuffer, endian, m.Header)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	binary.Write(bytesBuffer, endian, m.Capabilities)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	binary.Write(bytesBuffer, endian, m.KaInterval)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	b = append(b, m.VendorId.Encode()...)
+
+	//slice for msgLen
+	msgLen := b[4:8]
+	binary.Write(bytesBuffer, endian, uint32(len(b)))
+	copy(msgLen, bytesBuffer.Bytes())
+
+	return b
 }
 
 func (m *ConnectResponse) Decode(msg []byte) error {
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
-
-	err := binary.Read(bytesBuffer, endian, &m.Header)
-	m.Capabilities = binary.BigEndian.Uint32(msg[8:12])
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 16, "capabilities"); err != nil {
+		return err
+	}
+	m.Capabilities = Capabilities(binary.BigEndian.Uint32(msg[8:12]))
 	m.KaInterval = binary.BigEndian.Uint32(msg[12:16])
-	m.VendorId.Length = binary.BigEndian.Uint32(msg[16:20])
-	m.VendorId.Str = make([]byte, m.VendorId.Length)
-	copy(m.VendorId.Str, msg[20:])
+	m.VendorId, _, err = decodeUTF8Field(msg[16:], "vendor id")
 
 	return err
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"log"
 )
 
+const (
+	DATA_FLAG_DUPLICATE byte = 0x01
+)
+
 type Data struct {
 	Header      MsgHdr
 	TemplateID  uint16
@@ -23,15 +26,19 @@ func (m *Data) Encode() []byte {
 
 func (m *Data) Decode(msg []byte) error {
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
-
-	err := binary.Read(bytesBuffer, endian, &m.Header)
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 21, "sequence num"); err != nil {
+		return err
+	}
 	m.TemplateID = binary.BigEndian.Uint16(msg[8:10])
 	m.ConfigID = binary.BigEndian.Uint16(msg[10:12])
 	m.Flags = msg[12]
 	m.SequenceNum = binary.BigEndian.Uint64(msg[13:21])
 	//data_len = msg_len - header_len - 13
-	m.Record = make([]byte, m.Header.MsgLen-8-13)
+	m.Record = make([]byte, len(msg)-8-13)
 
 	copy(m.Record, msg[21:])
 
@@ -40,8 +47,8 @@ func (m *Data) Decode(msg []byte) error {
 }
 
 func (m *Data) Desc() string {
-	return fmt.Sprintf("DATA - id: %d, seq: %d",
-		m.Header.SessId, m.SequenceNum)
+	return fmt.Sprintf("DATA - id: %d, seq: %d, flags: 0x%x",
+		m.Header.SessId, m.SequenceNum, m.Flags)
 }
 
 var i int
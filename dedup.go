@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// What to do with a DATA flagged duplicate which is not in the dedup window.
+type DedupPolicy string
+
+const (
+	DEDUP_DROP     DedupPolicy = "drop"
+	DEDUP_SEPARATE DedupPolicy = "separate"
+	DEDUP_MARK     DedupPolicy = "mark"
+)
+
+type dedupKey struct {
+	docId [16]byte
+	seq   uint64
+}
+
+// Bounded window of recently written records of a session, oldest key is
+// evicted first. Kept per exporter and session id across reconnects, the
+// exporter replays unacked records after a reconnect.
+type DedupWindow struct {
+	mutex    sync.Mutex
+	size     int
+	keys     []dedupKey
+	next     int
+	seen     map[dedupKey]struct{}
+	fileName string
+	dirty    bool
+}
+
+func NewDedupWindow(size int, fileName string) *DedupWindow {
+	w := &DedupWindow{
+		size:     size,
+		seen:     make(map[dedupKey]struct{}),
+		fileName: fileName,
+	}
+	return w
+}
+
+func newDedupKey(docId []byte, seq uint64) dedupKey {
+	k := dedupKey{seq: seq}
+	copy(k.docId[:], docId)
+	return k
+}
+
+func (w *DedupWindow) Seen(docId []byte, seq uint64) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, ok := w.seen[newDedupKey(docId, seq)]
+	return ok
+}
+
+func (w *DedupWindow) Add(docId []byte, seq uint64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.add(newDedupKey(docId, seq)) {
+		w.dirty = true
+	}
+}
+
+func (w *DedupWindow) add(k dedupKey) bool {
+	if _, ok := w.seen[k]; ok {
+		return false
+	}
+	if len(w.keys) < w.size {
+		w.keys = append(w.keys, k)
+	} else {
+		delete(w.seen, w.keys[w.next])
+		w.keys[w.next] = k
+	}
+	w.next = (w.next + 1) % w.size
+	w.seen[k] = struct{}{}
+	return true
+}
+
+// Load the window saved by a previous run, a missing file is not an error.
+func (w *DedupWindow) Load() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.fileName == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(w.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(b)%24 != 0 {
+		return fmt.Errorf("%s: bad length %d", w.fileName, len(b))
+	}
+	for ; len(b) > 0; b = b[24:] {
+		k := dedupKey{seq: binary.BigEndian.Uint64(b[16:24])}
+		copy(k.docId[:], b[:16])
+		w.add(k)
+	}
+	return nil
+}
+
+// Write the window oldest key first, through a temp file so a crash leaves
+// either the old or the new window.
+func (w *DedupWindow) Save() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.fileName == "" || !w.dirty {
+		return nil
+	}
+	bytesBuffer := bytes.NewBuffer([]byte{})
+	for i := range w.keys {
+		k := w.keys[(w.next+i)%len(w.keys)]
+		bytesBuffer.Write(k.docId[:])
+		binary.Write(bytesBuffer, endian, k.seq)
+	}
+
+	tmpName := w.fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(bytesBuffer.Bytes()); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, w.fileName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// Must hold sessionMutex.
+func (e *Exporter) getDedupConfig(sessId byte) *ConfigDedup {
+	if cfg, ok := e.sessionConfigs[sessId]; ok && cfg != nil {
+		return &cfg.Dedup
+	}
+	return &ConfigDedup{}
+}
+
+// Must hold sessionMutex.
+func (e *Exporter) getDedupWindow(sessId byte) *DedupWindow {
+	if w, ok := e.dedup[sessId]; ok {
+		return w
+	}
+
+	cfg := e.getDedupConfig(sessId)
+	fileName := ""
+	if cfg.Persist {
+		fileName = filepath.Join(GetStateDir(),
+			fmt.Sprintf("IPDR_DEDUP_%s_S%d.dat", fileNameSafe(e.Name), sessId))
+	}
+	w := NewDedupWindow(cfg.GetWindow(), fileName)
+	if err := w.Load(); err != nil {
+		e.logf("Load dedup window of sess %d: %s\n", sessId, err)
+	}
+	e.dedup[sessId] = w
+	return w
+}
+
+// Decide whether a DATA is written, and whether as a duplicate. Records
+// already in the window are never written again, they are still acked.
+// The record is added to the window once stored.
+func (e *Exporter) dedupRecord(s *Session, d *Data) (bool, bool) {
+	w := e.getDedupWindow(s.Id)
+	if w.Seen(s.DocID, d.SequenceNum) {
+		s.Stats.Replayed++
+		return false, false
+	}
+	if d.Flags&DATA_FLAG_DUPLICATE == 0 {
+		return true, false
+	}
+	s.Stats.FlaggedDuplicates++
+	if e.getDedupConfig(s.Id).GetPolicy() == DEDUP_DROP {
+		return false, true
+	}
+	return true, true
+}
+
+func (s *Session) saveDedup() {
+	if w, ok := s.exporter.dedup[s.Id]; ok {
+		if err := w.Save(); err != nil {
+			s.exporter.logf("Save dedup window of sess %d: %s\n", s.Id, err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupWindowSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "IPDR_DEDUP_test_S1.dat")
+	docId := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	w := NewDedupWindow(2, fileName)
+	for seq := uint64(1); seq <= 3; seq++ {
+		w.Add(docId, seq)
+	}
+	if err = w.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(fileName + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left: %v", err)
+	}
+
+	loaded := NewDedupWindow(2, fileName)
+	if err = loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for seq, seen := range map[uint64]bool{1: false, 2: true, 3: true} {
+		if loaded.Seen(docId, seq) != seen {
+			t.Errorf("seq %d seen %v, want %v", seq, !seen, seen)
+		}
+	}
+}
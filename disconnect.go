@@ -3,8 +3,6 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
-	"fmt"
 )
 
 type Disconnect struct {
@@ -24,7 +22,7 @@ func (m *Disconnect) Encode() []byte {
 
 func (m *Disconnect) Decode(msg []byte) error {
 
-	return errors.New(fmt.Sprintf("Not support decode for %s", m.Desc()))
+	return decodeHeader(msg, &m.Header)
 }
 
 func (m *Disconnect) Desc() string {
@@ -35,3 +33,19 @@ func (m *Disconnect) RespMsg() []IPDRMsg {
 
 	return nil
 }
+
+func NewDisconnectMsg() IPDRMsg {
+	var h MsgHdr = MsgHdr{
+		Version: 2,
+		MsgId:   DISCONNECT,
+		SessId:  0,
+		MsgFlag: 0,
+		MsgLen:  8,
+	}
+
+	m := &Disconnect{
+		Header: h,
+	}
+
+	return m
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"strings"
+)
+
+// How enumerated fields are written, by the template config "enums".
+type EnumMode string
+
+const (
+	// The raw value only, the default.
+	ENUM_RAW EnumMode = "raw"
+	// The enumeration name in place of the value, unknown values stay raw.
+	ENUM_NAME EnumMode = "name"
+	// The value, then a "<field>Name" column with its name.
+	ENUM_BOTH EnumMode = "both"
+)
+
+var (
+	docsisRecTypeEnums = []SchemaEnum{
+		{"1", "interim"},
+		{"2", "stop"},
+	}
+	docsisQosVersionEnums = []SchemaEnum{
+		{"1", "docsis10"},
+		{"2", "docsis11"},
+	}
+	docsisServiceDirectionEnums = []SchemaEnum{
+		{"1", "downstream"},
+		{"2", "upstream"},
+	}
+	// CmtsCmRegState of DOCS-IF3-MIB, 3 and 7 are not used.
+	docsisCmRegStatusEnums = []SchemaEnum{
+		{"1", "other"},
+		{"2", "initialRanging"},
+		{"4", "rangingAutoAdjComplete"},
+		{"5", "dhcpv4Complete"},
+		{"6", "registrationComplete"},
+		{"8", "operational"},
+		{"9", "bpiInit"},
+		{"10", "startEae"},
+		{"11", "startDhcpv4"},
+		{"12", "startDhcpv6"},
+		{"13", "dhcpv6Complete"},
+		{"14", "startConfigFileDownload"},
+		{"15", "configFileDownloadComplete"},
+		{"16", "startRegistration"},
+		{"17", "forwardingDisabled"},
+		{"18", "rfMuteAll"},
+	}
+	docsisModulationTypeEnums = []SchemaEnum{
+		{"0", "unknown"},
+		{"1", "tdma"},
+		{"2", "atdma"},
+		{"3", "scdma"},
+		{"4", "tdmaAndAtdma"},
+	}
+	docsisRangingStatusEnums = []SchemaEnum{
+		{"1", "other"},
+		{"2", "aborted"},
+		{"3", "retriesExceeded"},
+		{"4", "success"},
+		{"5", "continue"},
+		{"6", "timeoutT4"},
+	}
+	// SpectrumAnalysisWindowFunction of DOCS-IF3-MIB.
+	docsisWindowFunctionEnums = []SchemaEnum{
+		{"0", "other"},
+		{"1", "hann"},
+		{"2", "blackmanHarris"},
+		{"3", "rectangular"},
+		{"4", "hamming"},
+		{"5", "flatTop"},
+		{"6", "gaussian"},
+		{"7", "chebyshev"},
+	}
+	// IANAifType of the CMTS interfaces.
+	docsisIfTypeEnums = []SchemaEnum{
+		{"127", "docsCableMaclayer"},
+		{"128", "docsCableDownstream"},
+		{"129", "docsCableUpstream"},
+		{"205", "docsCableUpstreamChannel"},
+	}
+)
+
+// Fields identifying the CMTS and MAC domain, common to the types.
+var docsisCmtsFields = []*SchemaField{
+	{Name: "CmtsHostName", Documentation: "FQDN of the CMTS"},
+	{Name: "CmtsSysUpTime", Units: "hundredths of a second"},
+	{Name: "CmtsIpv4Addr"},
+	{Name: "CmtsIpv6Addr"},
+	{Name: "CmtsMdIfName"},
+	{Name: "CmtsMdIfIndex"},
+}
+
+var docsisCmFields = []*SchemaField{
+	{Name: "CmMacAddr"},
+	{Name: "CmIpv4Addr"},
+	{Name: "CmIpv6Addr"},
+	{Name: "CmIpv6LinkLocalAddr"},
+	{Name: "CmQosVersion", Enums: docsisQosVersionEnums},
+	{Name: "CmRegStatusValue", Enums: docsisCmRegStatusEnums},
+	{Name: "CmLastRegTime"},
+}
+
+var docsisServiceFields = []*SchemaField{
+	{Name: "RecType", Enums: docsisRecTypeEnums},
+	{Name: "ServiceFlowChSet"},
+	{Name: "ServiceAppId"},
+	{Name: "ServiceDsMulticast"},
+	{Name: "ServiceIdentifier"},
+	{Name: "ServiceGateId"},
+	{Name: "ServiceClassName"},
+	{Name: "ServiceDirection", Enums: docsisServiceDirectionEnums},
+	{Name: "ServiceOctetsPassed", Units: "octets"},
+	{Name: "ServicePktsPassed", Units: "packets"},
+	{Name: "ServiceSlaDropPkts", Units: "packets"},
+	{Name: "ServiceSlaDelayPkts", Units: "packets"},
+	{Name: "ServiceTimeCreated", Units: "hundredths of a second"},
+	{Name: "ServiceTimeActive", Units: "seconds"},
+}
+
+// Namespace of the DOCSIS 3.0 service definitions, followed by their name.
+const DOCSIS_NAMESPACE = "http://www.cablelabs.com/namespaces/DOCSIS/3.0/xsd/ipdr/"
+
+func docsisType(name string, fields ...[]*SchemaField) *SchemaType {
+	st := &SchemaType{Name: name, Namespace: DOCSIS_NAMESPACE + name, FileName: "built in", Builtin: true}
+	for _, f := range fields {
+		st.Fields = append(st.Fields, f...)
+	}
+	return st
+}
+
+// Built in catalog of the DOCSIS 3.x IPDR service definitions SAMIS-TYPE-1,
+// SAMIS-TYPE-2, CMTS-CM-REG-STATUS, CMTS-CM-US-STATS, CPE-TYPE,
+// DS-SPECTRUM-ANALYSIS and CMTS-TOPOLOGY, used for templates with no XSD
+// type in the "schema-dir". It gives the names of the numeric enumerations
+// and the units of the counters, templates are not checked against it.
+var docsisCatalog = newBuiltinCatalog(
+	docsisType("DOCSIS-SAMIS-TYPE-1", docsisCmtsFields, docsisCmFields, docsisServiceFields),
+	docsisType("DOCSIS-SAMIS-TYPE-2", docsisCmtsFields, docsisCmFields, docsisServiceFields),
+	docsisType("DOCSIS-CMTS-CM-REG-STATUS-TYPE", docsisCmtsFields, docsisCmFields),
+	docsisType("DOCSIS-CMTS-CM-US-STATS-TYPE", docsisCmtsFields, []*SchemaField{
+		{Name: "CmMacAddr"},
+		{Name: "CmtsCmUsChIfName"},
+		{Name: "CmtsCmUsChIfIndex"},
+		{Name: "CmtsCmUsModulationType", Enums: docsisModulationTypeEnums},
+		{Name: "CmtsCmUsRxPower", Units: "TenthdBmV"},
+		{Name: "CmtsCmUsSignalNoise", Units: "TenthdB"},
+		{Name: "CmtsCmUsMicroreflections", Units: "-dBc"},
+		{Name: "CmtsCmUsEqData"},
+		{Name: "CmtsCmUsUnerroreds", Units: "codewords"},
+		{Name: "CmtsCmUsCorrecteds", Units: "codewords"},
+		{Name: "CmtsCmUsUncorrectables", Units: "codewords"},
+		{Name: "CmtsCmUsHighResolutionTimingOffset"},
+		{Name: "CmtsCmUsIsMuted"},
+		{Name: "CmtsCmUsRangingStatus", Enums: docsisRangingStatusEnums},
+	}),
+	docsisType("DOCSIS-CPE-TYPE", docsisCmtsFields, []*SchemaField{
+		{Name: "CmMacAddr"},
+		{Name: "CpeMacAddr"},
+		{Name: "CpeIpv4Addr"},
+		{Name: "CpeIpv6Addr"},
+		{Name: "CpeFqdn"},
+	}),
+	// The CM downstream spectrum, as docsIf3CmSpectrumAnalysisCtrlCmd and
+	// docsIf3CmSpectrumAnalysisMeasTable.
+	docsisType("DOCSIS-DS-SPECTRUM-ANALYSIS-TYPE", docsisCmtsFields, []*SchemaField{
+		{Name: "CmMacAddr"},
+		{Name: "FirstSegmentCenterFrequency", Units: "hertz"},
+		{Name: "LastSegmentCenterFrequency", Units: "hertz"},
+		{Name: "SegmentFrequencySpan", Units: "hertz"},
+		{Name: "NumBinsPerSegment", Units: "bins"},
+		{Name: "EquivalentNoiseBandwidth", Units: "hundredths of bin spacing"},
+		{Name: "WindowFunction", Enums: docsisWindowFunctionEnums},
+		{Name: "NumberOfAverages"},
+		{Name: "Frequency", Units: "hertz", Documentation: "center frequency of the segment"},
+		{Name: "AmplitudeData", Documentation: "bin amplitudes in hundredths of dBmV"},
+	}),
+	docsisType("DOCSIS-CMTS-TOPOLOGY-TYPE", docsisCmtsFields, []*SchemaField{
+		{Name: "CmtsMdCmSgId"},
+		{Name: "CmtsMdDsSgId"},
+		{Name: "CmtsMdUsSgId"},
+		{Name: "CmtsRcpId"},
+		{Name: "CmtsRcsId"},
+		{Name: "CmtsTcsId"},
+		{Name: "ChIfIndex"},
+		{Name: "ChIfName"},
+		{Name: "ChIfType", Enums: docsisIfTypeEnums},
+		{Name: "ChFrequency", Units: "hertz"},
+	}),
+)
+
+func newBuiltinCatalog(types ...*SchemaType) *SchemaCatalog {
+	c := &SchemaCatalog{
+		types: make(map[string][]*SchemaType),
+		byNs:  make(map[string][]*SchemaType),
+	}
+	for _, st := range types {
+		c.add(st)
+	}
+	return c
+}
+
+// Type of the service definition named in a schema name, e.g.
+// ".../ipdr/DOCSIS-SAMIS-TYPE-1/DOCSIS-SAMIS-TYPE-1_3.5.1-A.1.xsd", whatever
+// the DOCSIS version of the namespace. The type name of the template is
+// mostly the generic "DOCSIS-Type".
+func (c *SchemaCatalog) serviceType(schemaName string) *SchemaType {
+	segs := strings.Split(schemaName, "/")
+	for i := len(segs) - 1; i >= 0; i-- {
+		name := strings.TrimSuffix(segs[i], ".xsd")
+		if n := strings.IndexByte(name, '_'); n >= 0 {
+			name = name[:n]
+		}
+		if types := c.types[name]; len(types) == 1 {
+			return types[0]
+		}
+	}
+	return nil
+}
+
+// Add the catalog metadata to the fields of a template, the type by the
+// service definition in the schema name, else by type name. Must hold
+// sessionMutex.
+func (c *SchemaCatalog) Annotate(t *Template) bool {
+	st := c.serviceType(t.SchemaName)
+	if st == nil {
+		st = c.Lookup(t.SchemaName, t.TypeName)
+	}
+	if st == nil {
+		return false
+	}
+	t.Schema = st
+	for _, f := range t.Fields {
+		name := localName(f.FieldName)
+		for _, sf := range st.Fields {
+			if sf.Name == name {
+				f.Schema = sf
+				break
+			}
+		}
+	}
+	return true
+}
+
+// Name of an enumeration value, as formatted for CSV.
+func (sf *SchemaField) EnumName(value string) (string, bool) {
+	for _, e := range sf.Enums {
+		if e.Value == value {
+			return e.Name, true
+		}
+	}
+	return "", false
+}
+
+// Schema of a basic field with enumerations, else nil.
+func enumField(f *Field) *SchemaField {
+	if f == nil || f.Schema == nil || len(f.Schema.Enums) == 0 || TypeID(f.TypeID).IsComplex() {
+		return nil
+	}
+	return f.Schema
+}
+
+// Header columns of a field by the template enum mode.
+func (t *Template) enumHeader(f *Field, cols []string) []string {
+	if t.Enums == ENUM_BOTH && enumField(f) != nil {
+		return append(cols, f.FieldName+"Name")
+	}
+	return cols
+}
+
+// CSV columns of a field value by the template enum mode, matching
+// enumHeader.
+func (t *Template) enumColumns(fv *FieldValue, cols []string) []string {
+	sf := enumField(fv.Field)
+	if sf == nil || len(cols) != 1 {
+		return cols
+	}
+	name, ok := sf.EnumName(cols[0])
+	switch t.Enums {
+	case ENUM_NAME:
+		if ok {
+			return []string{csvQuote(name)}
+		}
+	case ENUM_BOTH:
+		return append(cols, csvQuote(name))
+	}
+	return cols
+}
+
+// Must hold sessionMutex.
+func (e *Exporter) getEnumMode(sessId byte, t *Template) EnumMode {
+	cfg, ok := e.sessionConfigs[sessId]
+	if !ok || cfg == nil {
+		return ENUM_RAW
+	}
+	ct := cfg.GetTemplateConfig(t.TemplateID, t.TypeName)
+	if ct == nil {
+		return ENUM_RAW
+	}
+	mode := ct.GetEnumMode()
+	if ct.Enums != "" && string(mode) != ct.Enums {
+		e.logf("Warning: sess %d template %d unknown enums \"%s\"\n", sessId, t.TemplateID, ct.Enums)
+	}
+	return mode
+}
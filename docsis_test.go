@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDocsisCmRegStatus(t *testing.T) {
+	tp := &Template{TypeName: "DOCSIS-CMTS-CM-REG-STATUS-TYPE", Fields: []*Field{
+		{TypeID: uint32(UINT), FieldName: "CmRegStatusValue", IsEnabled: true},
+	}}
+	if !docsisCatalog.Annotate(tp) {
+		t.Fatal("no catalog type")
+	}
+	for value, name := range map[string]string{
+		"1": "other", "4": "rangingAutoAdjComplete", "6": "registrationComplete",
+		"8": "operational", "13": "dhcpv6Complete", "18": "rfMuteAll",
+	} {
+		if got, _ := tp.Fields[0].Schema.EnumName(value); got != name {
+			t.Errorf("CmRegStatusValue %s is %q, want %q", value, got, name)
+		}
+	}
+	for _, value := range []string{"3", "7"} {
+		if got, ok := tp.Fields[0].Schema.EnumName(value); ok {
+			t.Errorf("CmRegStatusValue %s is %q, want none", value, got)
+		}
+	}
+
+	fv := &FieldValue{Name: "CmRegStatusValue", Type: UINT, Value: uint32(8), Field: tp.Fields[0]}
+	for mode, want := range map[EnumMode][]string{
+		ENUM_RAW:  {"8"},
+		ENUM_NAME: {"operational"},
+		ENUM_BOTH: {"8", "operational"},
+	} {
+		tp.Enums = mode
+		got := tp.enumColumns(fv, formatColumns(fv.Type, fv.Value))
+		header := tp.enumHeader(tp.Fields[0], []string{"CmRegStatusValue"})
+		if len(got) != len(want) || len(header) != len(want) {
+			t.Errorf("%s: columns %v, header %v, want %v", mode, got, header, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: columns %v, want %v", mode, got, want)
+			}
+		}
+	}
+}
+
+func TestDocsisSpectrumTopology(t *testing.T) {
+	for _, tc := range []struct {
+		typeName, field, value, name string
+	}{
+		{"DOCSIS-DS-SPECTRUM-ANALYSIS-TYPE", "WindowFunction", "2", "blackmanHarris"},
+		{"DOCSIS-CMTS-TOPOLOGY-TYPE", "ChIfType", "129", "docsCableUpstream"},
+	} {
+		tp := &Template{TypeName: tc.typeName, Fields: []*Field{
+			{TypeID: uint32(UINT), FieldName: tc.field, IsEnabled: true},
+		}}
+		if !docsisCatalog.Annotate(tp) || tp.Fields[0].Schema == nil {
+			t.Errorf("%s: no catalog field %s", tc.typeName, tc.field)
+			continue
+		}
+		if got, _ := tp.Fields[0].Schema.EnumName(tc.value); got != tc.name {
+			t.Errorf("%s %s %s is %q, want %q", tc.typeName, tc.field, tc.value, got, tc.name)
+		}
+	}
+}
+
+func TestDocsisServiceDefinition(t *testing.T) {
+	for schemaName, want := range map[string]string{
+		DOCSIS_NAMESPACE + "DOCSIS-SAMIS-TYPE-1":                                   "DOCSIS-SAMIS-TYPE-1",
+		DOCSIS_NAMESPACE + "DOCSIS-SAMIS-TYPE-2/DOCSIS-SAMIS-TYPE-2_3.5.1-A.1.xsd": "DOCSIS-SAMIS-TYPE-2",
+		"http://www.cablelabs.com/namespaces/DOCSIS/3.1/xsd/ipdr/DOCSIS-CPE-TYPE":  "DOCSIS-CPE-TYPE",
+		"DOCSIS-CMTS-TOPOLOGY-TYPE_3.5.1-A.1.xsd":                                  "DOCSIS-CMTS-TOPOLOGY-TYPE",
+		DOCSIS_NAMESPACE + "DOCSIS-SAMIS-TYPE-10":                                  "",
+		"http://example.com/ipdr/VOIP":                                             "",
+	} {
+		tp := &Template{SchemaName: schemaName, TypeName: "DOCSIS-Type", Fields: []*Field{
+			{TypeID: uint32(UINT), FieldName: "CmtsMdIfIndex", IsEnabled: true},
+		}}
+		ok := docsisCatalog.Annotate(tp)
+		if want == "" {
+			if ok {
+				t.Errorf("%s: catalog type %s", schemaName, tp.Schema.Name)
+			}
+			continue
+		}
+		if !ok || tp.Schema.Name != want {
+			t.Errorf("%s: catalog type %v, want %s", schemaName, tp.Schema, want)
+			continue
+		}
+		if tp.Fields[0].Schema == nil {
+			t.Errorf("%s: field CmtsMdIfIndex not annotated", schemaName)
+		}
+	}
+}
@@ -43,14 +43,16 @@ func (m *Error) Encode() []byte {
 
 func (m *Error) Decode(msg []byte) error {
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
-
-	err := binary.Read(bytesBuffer, endian, &m.Header)
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 14, "error code"); err != nil {
+		return err
+	}
 	m.TimeStamp = binary.BigEndian.Uint32(msg[8:12])
 	m.ErrorCode = binary.BigEndian.Uint16(msg[12:14])
-	m.Description.Length = binary.BigEndian.Uint32(msg[14:18])
-	m.Description.Str = make([]byte, m.Description.Length)
-	copy(m.Description.Str, msg[18:])
+	m.Description, _, err = decodeUTF8Field(msg[14:], "description")
 
 	return err
 }
@@ -80,6 +82,43 @@ var errorCode map[uint16]string = map[uint16]string{
 	ERR_MSG_PROCESS_TERMINATING:      "Process terminating",
 }
 
+// How the collector reacts to an ERROR from the exporter.
+type ErrorAction int
+
+const (
+	ERR_ACTION_NONE ErrorAction = iota
+	ERR_ACTION_RESET_CONNECTION
+	ERR_ACTION_RESTART_FLOWS
+	ERR_ACTION_BACKOFF
+)
+
+var errorAction map[uint16]ErrorAction = map[uint16]ErrorAction{
+	ERR_KEEPALIVE_EXPIRED:            ERR_ACTION_RESET_CONNECTION,
+	ERR_MSG_INVALID_FOR_CAPABILITIES: ERR_ACTION_RESET_CONNECTION,
+	ERR_MSG_INVALID_FOR_STATE:        ERR_ACTION_RESTART_FLOWS,
+	ERR_MSG_DECODE_ERROR:             ERR_ACTION_RESET_CONNECTION,
+	ERR_MSG_PROCESS_TERMINATING:      ERR_ACTION_BACKOFF,
+}
+
+func GetErrorAction(errCode uint16) ErrorAction {
+	if action, ok := errorAction[errCode]; ok {
+		return action
+	}
+	return ERR_ACTION_NONE
+}
+
+func (a ErrorAction) String() string {
+	switch a {
+	case ERR_ACTION_RESET_CONNECTION:
+		return "reset connection"
+	case ERR_ACTION_RESTART_FLOWS:
+		return "restart flows"
+	case ERR_ACTION_BACKOFF:
+		return "back off"
+	}
+	return "none"
+}
+
 func NewErrorMsg(errCode uint16, desc string) IPDRMsg {
 
 	var h MsgHdr = MsgHdr{
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Per exporter state. Every exporter has its own connection, session table
+// and keepalive timers, so a failing exporter does not affect the others.
+type Exporter struct {
+	Name   string
+	config *ConfigExporter
+
+	sendChan   chan []byte
+	msgChan    chan connMsg
+	activeConn *Connection
+	connMutex  sync.Mutex
+	prevConn   *Connection
+	prevDone   chan struct{}
+	acceptLock sync.Mutex
+
+	sessions       map[byte]*Session
+	sessionMutex   sync.RWMutex
+	state          ConnState
+	advertisedCaps Capabilities
+	capabilities   Capabilities
+	requestId      uint16
+
+	advertisedSessions []SessionBlock
+	flowSessIds        []byte
+	sessionConfigs     map[byte]*ConfigSession
+	templateSets       map[byte]*TemplateSet
+	dedup              map[byte]*DedupWindow
+	checkpoints        map[byte]*SessionCheckpoint
+	documents          map[byte]*Document
+	checkpointMutex    sync.Mutex
+	kaSendInterval     uint32
+	kaRecvInterval     uint32
+	lastKaSendTime     time.Time
+	kaMutex            sync.Mutex // kaRecvInterval and lastKaSendTime
+	kaRecvTimer        *time.Timer
+	resetChan          chan chan struct{}
+	shutdownChan       chan chan struct{}
+	stopping           bool
+	holdOff            bool // guarded by connMutex
+}
+
+func NewExporter(cfg *ConfigExporter) *Exporter {
+	e := &Exporter{
+		Name:           cfg.GetName(),
+		config:         cfg,
+		sendChan:       make(chan []byte, 1),
+		msgChan:        make(chan connMsg),
+		resetChan:      make(chan chan struct{}),
+		shutdownChan:   make(chan chan struct{}),
+		sessions:       make(map[byte]*Session),
+		templateSets:   make(map[byte]*TemplateSet),
+		sessionConfigs: make(map[byte]*ConfigSession),
+		dedup:          make(map[byte]*DedupWindow),
+		checkpoints:    make(map[byte]*SessionCheckpoint),
+		documents:      make(map[byte]*Document),
+		kaSendInterval: 300,
+		kaRecvInterval: 300, //default 300 seconds
+		state:          CONN_STOPPED,
+	}
+	e.kaRecvTimer = time.NewTimer(time.Second * time.Duration(e.kaRecvInterval))
+
+	return e
+}
+
+func (e *Exporter) logf(format string, v ...interface{}) {
+	log.Printf("[%s] "+format, append([]interface{}{e.Name}, v...)...)
+}
+
+func (e *Exporter) Start() {
+	if err := e.LoadCheckpoints(); err != nil {
+		e.logf("Load checkpoints: %s\n", err)
+	}
+	e.SessionMgrInit()
+	go e.SenderRoutine()
+	if !IsPassiveMode() {
+		go e.ConnectRoutine()
+	}
+}
+
+// Ack what was received, stop all flows and disconnect, then close the
+// outputs. Gives up on the exporter after timeout.
+func (e *Exporter) Shutdown(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	if c := e.getActiveConn(); c != nil {
+		c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	done := make(chan struct{})
+	select {
+	case e.shutdownChan <- done:
+		select {
+		case <-done:
+		case <-timer.C:
+			e.logf("Drain timeout, %v\n", timeout)
+		}
+	case <-timer.C:
+		e.logf("Drain timeout, %v\n", timeout)
+	}
+
+	e.CloseConnection(errors.New("collector terminating"))
+}
+
+// Check whether a connection accepted in passive mode is from this exporter.
+func (e *Exporter) MatchAddr(ip net.IP) bool {
+	if cfgIP := net.ParseIP(e.config.Address); cfgIP != nil {
+		return cfgIP.Equal(ip)
+	}
+
+	addrs, err := net.LookupIP(e.config.Address)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func FindExporterByAddr(ip net.IP) *Exporter {
+	for _, e := range exporters {
+		if e.MatchAddr(ip) {
+			return e
+		}
+	}
+	return nil
+}
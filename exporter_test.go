@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func testTemplateData(sessId byte) *TemplateData {
+	return &TemplateData{
+		Header:   MsgHdr{MsgId: TEMPLATE_DATA, SessId: sessId},
+		ConfigID: 1,
+		Templates: []TemplateBlock{{
+			TemplateID: 1,
+			TypeName:   UTF8String{Str: []byte("TEST-TYPE")},
+			Fields: []FieldDescriptor{
+				{TypeID: uint32(UINT), FieldID: 1, FieldName: UTF8String{Str: []byte("Counter")}, IsEnabled: 1},
+			},
+		}},
+	}
+}
+
+// A connection to nowhere, whatever is sent on it is discarded.
+func testConnection() *Connection {
+	local, remote := net.Pipe()
+	go io.Copy(ioutil.Discard, remote)
+	return NewConnection(local)
+}
+
+// Connections come and go while the session mgr handles DATA, run with
+// -race.
+func TestSessionMgrResetRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	e := NewExporter(&ConfigExporter{Name: "race"})
+	e.SessionMgrInit()
+	go e.SenderRoutine()
+	e.setActiveConn(testConnection())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			// As RunConnection does.
+			e.getActiveConn().Close(io.EOF)
+			c := testConnection()
+			e.resetSessionMgr()
+			e.setActiveConn(c)
+			e.SetKaRecvInterval(30)
+			e.SetConnState(CONN_CONNECTED)
+			e.StartFlows([]byte{1})
+			e.RcvMsg(c, testTemplateData(1))
+			e.RcvMsg(c, &SessionStart{
+				Header:          MsgHdr{MsgId: SESSION_START, SessId: 1},
+				Primary:         1,
+				AckTimeInterval: 1,
+				DocumentID:      []byte{byte(i), 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			})
+			e.setHoldOff()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	record := []byte{0, 0, 0, 4, 0, 0, 0, 1}
+	for seq := uint64(0); ; seq++ {
+		select {
+		case <-done:
+			// Close the outputs before the dir goes.
+			e.getActiveConn().Close(io.EOF)
+			e.resetSessionMgr()
+			return
+		default:
+		}
+		e.RcvMsg(e.getActiveConn(), &Data{
+			Header:      MsgHdr{MsgId: DATA, SessId: 1},
+			TemplateID:  1,
+			ConfigID:    1,
+			SequenceNum: seq,
+			Record:      record,
+		})
+		e.UpdateLastKaRcvdTime()
+		e.takeHoldOff()
+	}
+}
+
+// A msg queued before a reset must not reach the sessions of the next
+// connection.
+func TestStaleConnectionMsg(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	e := NewExporter(&ConfigExporter{Name: "stale"})
+	old, c := testConnection(), testConnection()
+	defer old.Close(io.EOF)
+	defer c.Close(io.EOF)
+	e.setActiveConn(c)
+	e.SetConnState(CONN_CONNECTED)
+	e.StartFlows([]byte{1})
+
+	e.handleMsg(old, testTemplateData(1))
+	if s := e.sessions[1]; s == nil || len(s.Templates) != 0 {
+		t.Fatal("TEMPLATE_DATA of the previous connection handled")
+	}
+	e.handleMsg(c, testTemplateData(1))
+	if s := e.sessions[1]; s == nil || len(s.Templates) != 1 {
+		t.Fatal("TEMPLATE_DATA of the active connection not handled")
+	}
+}
+
+// In passive mode the exporter sends the CONNECT, we answer it and go on
+// with GET_SESSIONS.
+func TestPassiveConnect(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	mode := config.Collector.Mode
+	config.Collector.Mode = "passive"
+	defer func() { config.Collector.Mode = mode }()
+
+	msg, err := msgDecode(testMsg(CONNECT, uint32(0x0a000001), uint16(4737),
+		uint32(CAP_STRUCTURES), uint32(30), testUTF8("vendor")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect, ok := msg.(*Connect)
+	if !ok || connect.InitPort != 4737 || connect.KaInterval != 30 || string(connect.VendorId.Str) != "vendor" {
+		t.Fatalf("decoded %+v", msg)
+	}
+
+	e := NewExporter(&ConfigExporter{Name: "passive", KeepAlive: 60})
+	c := testConnection()
+	defer c.Close(io.EOF)
+	e.setActiveConn(c)
+	e.SetConnState(CONN_CONNECTING)
+	e.SetKaRecvInterval(60)
+	go e.handleMsg(c, connect)
+
+	resp := &ConnectResponse{}
+	if err = resp.Decode(<-e.sendChan); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.MsgId != CONNECT_RESPONSE || resp.KaInterval != 60 {
+		t.Errorf("response %+v", resp)
+	}
+	if next := <-e.sendChan; MessageID(next[1]) != GET_SESSIONS {
+		t.Errorf("msg 0x%x after CONNECT_RESPONSE, want GET_SESSIONS", next[1])
+	}
+	e.sessionMutex.RLock()
+	defer e.sessionMutex.RUnlock()
+	if e.state != CONN_CONNECTED || e.capabilities != CAP_STRUCTURES&e.advertisedCaps {
+		t.Errorf("conn state %s, capabilities %s", e.state, e.capabilities)
+	}
+}
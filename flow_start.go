@@ -35,3 +35,19 @@ func (m *FlowStart) RespMsg() []IPDRMsg {
 
 	return nil
 }
+
+func NewFlowStartMsg(sessId byte) IPDRMsg {
+	var h MsgHdr = MsgHdr{
+		Version: 2,
+		MsgId:   FLOW_START,
+		SessId:  sessId,
+		MsgFlag: 0,
+		MsgLen:  8,
+	}
+
+	m := &FlowStart{
+		Header: h,
+	}
+
+	return m
+}
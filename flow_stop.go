@@ -48,3 +48,23 @@ func (m *FlowStop) RespMsg() []IPDRMsg {
 
 	return nil
 }
+
+func NewFlowStopMsg(sessId byte, reasonCode uint16, reason string) IPDRMsg {
+	var h MsgHdr = MsgHdr{
+		Version: 2,
+		MsgId:   FLOW_STOP,
+		SessId:  sessId,
+		MsgFlag: 0,
+	}
+
+	m := &FlowStop{
+		Header:     h,
+		ReasonCode: reasonCode,
+		ReasonInfo: UTF8String{
+			Length: uint32(len(reason)),
+			Str:    []byte(reason),
+		},
+	}
+
+	return m
+}
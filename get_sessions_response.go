@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"log"
 )
@@ -20,6 +19,8 @@ type GetSessionsResponse struct {
 	RequestId     uint16
 	BlockLength   uint32
 	SessionBlocks []SessionBlock
+	// Sessions to send FLOW_START for, set by the exporter owning the msg.
+	FlowSessIds []byte
 }
 
 func (m *GetSessionsResponse) Encode() []byte {
@@ -29,43 +30,50 @@ func (m *GetSessionsResponse) Encode() []byte {
 
 func (m *GetSessionsResponse) Decode(msg []byte) error {
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
-
-	err := binary.Read(bytesBuffer, endian, &m.Header)
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 14, "block length"); err != nil {
+		return err
+	}
 	m.RequestId = binary.BigEndian.Uint16(msg[8:10])
 	m.BlockLength = binary.BigEndian.Uint32(msg[10:14])
 
+	var length uint32
 	msg = msg[14:]
-	for {
+	for len(msg) > 0 {
 		s := SessionBlock{}
+		if err = checkMsgLen(msg, 2, "session id"); err != nil {
+			return err
+		}
 		s.SessId = msg[0]
 		s.Reserved = msg[1]
 		msg = msg[2:]
 
-		length := binary.BigEndian.Uint32(msg[:4])
-		s.SessName.Length = length
-		s.SessName.Str = make([]byte, length)
-		copy(s.SessName.Str, msg[4:length+4])
-		msg = msg[length+4:]
+		s.SessName, length, err = decodeUTF8Field(msg, "session name")
+		if err != nil {
+			return err
+		}
+		msg = msg[length:]
 
-		length = binary.BigEndian.Uint32(msg[:4])
-		s.SessDesc.Length = length
-		s.SessDesc.Str = make([]byte, length)
-		copy(s.SessDesc.Str, msg[4:length+4])
-		msg = msg[length+4:]
+		s.SessDesc, length, err = decodeUTF8Field(msg, "session description")
+		if err != nil {
+			return err
+		}
+		msg = msg[length:]
 
+		if err = checkMsgLen(msg, 8, "ack interval"); err != nil {
+			return err
+		}
 		s.AckTimeInterval = binary.BigEndian.Uint32(msg[:4])
 		s.AckSequenceInterval = binary.BigEndian.Uint32(msg[4:8])
 		msg = msg[8:]
 
 		m.SessionBlocks = append(m.SessionBlocks, s)
-
-		if len(msg) == 0 {
-			break
-		}
 	}
 
-	return err
+	return nil
 }
 
 func (m *GetSessionsResponse) Desc() string {
@@ -74,9 +82,7 @@ func (m *GetSessionsResponse) Desc() string {
 
 func (m *GetSessionsResponse) RespMsg() []IPDRMsg {
 	msgs := []IPDRMsg{}
-	sessIds := GetSessionList()
-
-	for _, id := range sessIds {
+	for _, id := range m.FlowSessIds {
 
 		var h MsgHdr = MsgHdr{
 			Version: 2,
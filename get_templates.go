@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type GetTemplates struct {
+	Header    MsgHdr
+	RequestId uint16
+}
+
+func (m *GetTemplates) Encode() []byte {
+
+	b := []byte{}
+	bytesBuffer := bytes.NewBuffer([]byte{})
+
+	binary.Write(bytesBuffer, endian, m)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	//slice for msgLen
+	msgLen := b[4:8]
+	binary.Write(bytesBuffer, endian, uint32(len(b)))
+	copy(msgLen, bytesBuffer.Bytes())
+
+	return b
+}
+
+func (m *GetTemplates) Decode(msg []byte) error {
+
+	return errors.New(fmt.Sprintf("Not support decode for %s", m.Desc()))
+}
+
+func (m *GetTemplates) Desc() string {
+	return fmt.Sprintf("GET_TEMPLATES - id: %d, req: %d", m.Header.SessId, m.RequestId)
+}
+
+func (m *GetTemplates) RespMsg() []IPDRMsg {
+
+	return nil
+}
+
+func NewGetTemplatesMsg(sessId byte, requestId uint16) IPDRMsg {
+	var h MsgHdr = MsgHdr{
+		Version: 2,
+		MsgId:   GET_TEMPLATES,
+		SessId:  sessId,
+		MsgFlag: 0,
+		MsgLen:  10,
+	}
+
+	m := &GetTemplates{
+		Header:    h,
+		RequestId: requestId,
+	}
+
+	return m
+}
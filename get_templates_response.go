@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+type GetTemplatesResponse struct {
+	Header    MsgHdr
+	RequestId uint16
+	ConfigID  uint16
+	Templates []TemplateBlock
+}
+
+func (m *GetTemplatesResponse) Encode() []byte {
+	log.Printf("Not support to Encode!\n")
+	return nil
+}
+
+func (m *GetTemplatesResponse) Decode(msg []byte) error {
+
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 12, "config id"); err != nil {
+		return err
+	}
+	m.RequestId = binary.BigEndian.Uint16(msg[8:10])
+	m.ConfigID = binary.BigEndian.Uint16(msg[10:12])
+
+	m.Templates, err = decodeTemplateBlocks(msg[12:])
+
+	return err
+}
+
+func (m *GetTemplatesResponse) Desc() string {
+	return fmt.Sprintf("GET_TEMPLATES_RESPONSE - id: %d, req: %d",
+		m.Header.SessId, m.RequestId)
+}
+
+func (m *GetTemplatesResponse) RespMsg() []IPDRMsg {
+
+	return nil
+}
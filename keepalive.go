@@ -23,10 +23,7 @@ func (m *KeepAlive) Encode() []byte {
 
 func (m *KeepAlive) Decode(msg []byte) error {
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
-	err := binary.Read(bytesBuffer, endian, &m.Header)
-
-	return err
+	return decodeHeader(msg, &m.Header)
 }
 
 func (m *KeepAlive) Desc() string {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type ModifyTemplate struct {
+	Header    MsgHdr
+	ConfigID  uint16
+	Flags     uint8
+	Templates []TemplateBlock
+}
+
+func (m *ModifyTemplate) Encode() []byte {
+
+	b := []byte{}
+	bytesBuffer := bytes.NewBuffer([]byte{})
+
+	binary.Write(bytesBuffer, endian, m.Header)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	binary.Write(bytesBuffer, endian, m.ConfigID)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	b = append(b, m.Flags)
+	b = append(b, encodeTemplateBlocks(m.Templates)...)
+
+	//slice for msgLen
+	msgLen := b[4:8]
+	binary.Write(bytesBuffer, endian, uint32(len(b)))
+	copy(msgLen, bytesBuffer.Bytes())
+
+	return b
+}
+
+func (m *ModifyTemplate) Decode(msg []byte) error {
+
+	return errors.New(fmt.Sprintf("Not support decode for %s", m.Desc()))
+}
+
+func (m *ModifyTemplate) Desc() string {
+	return fmt.Sprintf("MODIFY_TEMPLATE - id: %d, templates: %d",
+		m.Header.SessId, len(m.Templates))
+}
+
+func (m *ModifyTemplate) RespMsg() []IPDRMsg {
+
+	return nil
+}
+
+func NewModifyTemplateMsg(sessId byte, configId uint16, templates []TemplateBlock) *ModifyTemplate {
+	var h MsgHdr = MsgHdr{
+		Version: 2,
+		MsgId:   MODIFY_TEMPLATE,
+		SessId:  sessId,
+		MsgFlag: 0,
+	}
+
+	m := &ModifyTemplate{
+		Header:    h,
+		ConfigID:  configId,
+		Flags:     0,
+		Templates: templates,
+	}
+
+	return m
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+type ModifyTemplateResponse struct {
+	Header    MsgHdr
+	ConfigID  uint16
+	Flags     uint8
+	Templates []TemplateBlock
+}
+
+func (m *ModifyTemplateResponse) Encode() []byte {
+	log.Printf("Not support to Encode!\n")
+	return nil
+}
+
+func (m *ModifyTemplateResponse) Decode(msg []byte) error {
+
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 11, "flags"); err != nil {
+		return err
+	}
+	m.ConfigID = binary.BigEndian.Uint16(msg[8:10])
+	m.Flags = msg[10]
+
+	m.Templates, err = decodeTemplateBlocks(msg[11:])
+
+	return err
+}
+
+func (m *ModifyTemplateResponse) Desc() string {
+	return fmt.Sprintf("MODIFY_TEMPLATE_RESPONSE - id: %d", m.Header.SessId)
+}
+
+func (m *ModifyTemplateResponse) RespMsg() []IPDRMsg {
+	msgs := []IPDRMsg{}
+
+	msgs = append(msgs, NewTemplateDataAckMsg(m.Header.SessId))
+
+	return msgs
+}
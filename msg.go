@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 )
 
 var (
@@ -65,16 +67,70 @@ func (s *UTF8String) Encode() []byte {
 	return b
 }
 
-func DecodeUTF8String(msg []byte) (UTF8String, uint32) {
+func DecodeUTF8String(msg []byte) (UTF8String, uint32, error) {
 
 	str := UTF8String{}
+	if len(msg) < 4 {
+		return str, 0, ErrMsgTruncated
+	}
 	msgLen := binary.BigEndian.Uint32(msg[:4])
+	if uint64(msgLen) > uint64(len(msg)-4) {
+		return str, 0, fmt.Errorf("%w, string length %d, remain %d",
+			ErrMsgTruncated, msgLen, len(msg)-4)
+	}
 	str.Length = msgLen
 	str.Str = make([]byte, msgLen)
 	msg = msg[4:]
 	copy(str.Str, msg[:msgLen])
 
-	return str, 4 + msgLen
+	return str, 4 + msgLen, nil
+}
+
+var ErrMsgTruncated = errors.New("msg truncated")
+
+var ErrMsgUnsupported = errors.New("Unsupported msg.")
+
+// Largest msg accepted, a longer msg_len is taken as a broken or hostile
+// frame rather than buffered.
+const MAX_MSG_LEN = 16 << 20
+
+// Error of a malformed msg, replied with ERR_MSG_DECODE_ERROR.
+type DecodeError struct {
+	MsgId MessageID
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode msg 0x%x: %s", byte(e.MsgId), e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Check msg has at least n bytes left for field.
+func checkMsgLen(msg []byte, n int, field string) error {
+	if len(msg) < n {
+		return fmt.Errorf("%s: %w, need %d bytes, have %d",
+			field, ErrMsgTruncated, n, len(msg))
+	}
+	return nil
+}
+
+func decodeUTF8Field(msg []byte, field string) (UTF8String, uint32, error) {
+	str, n, err := DecodeUTF8String(msg)
+	if err != nil {
+		return str, n, fmt.Errorf("%s: %w", field, err)
+	}
+	return str, n, nil
+}
+
+func decodeHeader(msg []byte, h *MsgHdr) error {
+	if err := checkMsgLen(msg, 8, "header"); err != nil {
+		return err
+	}
+	bytesBuffer := bytes.NewBuffer(msg[:8])
+	return binary.Read(bytesBuffer, endian, h)
 }
 
 type MsgHdr struct {
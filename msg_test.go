@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// Msg of id with payload, header length filled in.
+func testMsg(id MessageID, payload ...interface{}) []byte {
+	bytesBuffer := bytes.NewBuffer([]byte{})
+	for _, p := range payload {
+		binary.Write(bytesBuffer, endian, p)
+	}
+	h := MsgHdr{Version: 2, MsgId: id, SessId: 1, MsgLen: uint32(8 + bytesBuffer.Len())}
+	b := bytes.NewBuffer([]byte{})
+	binary.Write(b, endian, h)
+	b.Write(bytesBuffer.Bytes())
+	return b.Bytes()
+}
+
+func testUTF8(s string) []byte {
+	b := make([]byte, 4, 4+len(s))
+	binary.BigEndian.PutUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func testTemplateDataMsg() []byte {
+	return testMsg(TEMPLATE_DATA, uint16(1), uint8(0), uint32(1),
+		uint16(1), testUTF8("schema"), testUTF8("TEST-TYPE"), uint32(1),
+		uint32(UINT), uint32(1), testUTF8("Counter"), uint8(1))
+}
+
+func FuzzMsgDecode(f *testing.F) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f.Add(testMsg(KEEP_ALIVE))
+	f.Add(testMsg(DISCONNECT))
+	f.Add(testTemplateDataMsg())
+	f.Add(testMsg(SESSION_START, uint32(0), uint64(0), uint64(0), uint8(1),
+		uint32(10), uint32(100), make([]byte, 16)))
+	f.Add(testMsg(SESSION_STOP, uint16(0), testUTF8("stop")))
+	f.Add(testMsg(DATA, uint16(1), uint16(1), uint8(0), uint64(7), uint32(4), uint32(9)))
+	f.Add(testMsg(ERROR, uint32(0), uint16(3), testUTF8("error")))
+	f.Add(testMsg(CONNECT, uint32(0x0a000001), uint16(4737), uint32(0x01), uint32(30), testUTF8("vendor")))
+	f.Add(testMsg(CONNECT_RESPONSE, uint32(0x01), uint32(30), testUTF8("vendor")))
+	f.Add(testMsg(GET_SESSIONS_RESPONSE, uint16(1), uint32(1), uint8(1), uint8(0),
+		testUTF8("SAMIS"), testUTF8("desc"), uint32(10), uint32(100)))
+
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		m, err := msgDecode(msg)
+		if err != nil {
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) && !errors.Is(err, ErrMsgUnsupported) {
+				t.Fatalf("error %v is not a DecodeError", err)
+			}
+			return
+		}
+		m.Desc()
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+)
+
+// Field names may be qualified with the schema namespace, match either form.
+func matchFieldName(name string, names []string) bool {
+	short := name
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		short = name[i+1:]
+	}
+	for _, n := range names {
+		if n == name || n == short {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply the configured field changes, only templates which change are
+// returned.
+func modifyTemplates(cs *ConfigSession, templates []TemplateBlock) []TemplateBlock {
+	modified := []TemplateBlock{}
+
+	for _, tb := range templates {
+		ct := cs.GetTemplateConfig(tb.TemplateID, string(tb.TypeName.Str))
+		if ct == nil {
+			continue
+		}
+		changed := false
+		mtb := tb
+		mtb.Fields = make([]FieldDescriptor, len(tb.Fields))
+		copy(mtb.Fields, tb.Fields)
+		for i := range mtb.Fields {
+			f := &mtb.Fields[i]
+			name := string(f.FieldName.Str)
+			if f.IsEnabled != 0 && matchFieldName(name, ct.Disable) {
+				f.IsEnabled = 0
+				changed = true
+			} else if f.IsEnabled == 0 && matchFieldName(name, ct.Enable) {
+				f.IsEnabled = 1
+				changed = true
+			}
+		}
+		if changed {
+			modified = append(modified, mtb)
+		}
+	}
+
+	return modified
+}
+
+// Decide how TEMPLATE_DATA is answered. Negotiable templates get
+// MODIFY_TEMPLATE, otherwise negotiation is asked for once by
+// START_NEGOTIATION after FINAL_TEMPLATE_DATA_ACK.
+func (e *Exporter) NegotiateTemplates(m *TemplateData) {
+	sessId := m.Header.SessId
+
+	cs := e.getSessionConfig(sessId)
+	if cs == nil {
+		return
+	}
+	modified := modifyTemplates(cs, m.Templates)
+	if len(modified) == 0 {
+		return
+	}
+	if !e.HasCapability(CAP_TEMPLATE_NEGOTIATION) {
+		e.logf("Template negotiation not agreed, sess %d keeps exporter templates\n", sessId)
+		return
+	}
+
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	s, ok := e.sessions[sessId]
+	if !ok {
+		return
+	}
+	if m.Flags&TEMPLATE_FLAG_NEGOTIABLE != 0 {
+		m.Modify = NewModifyTemplateMsg(sessId, m.ConfigID, modified)
+		s.setState(SESS_TEMPLATE_NEGOTIATING)
+		return
+	}
+	if !s.NegotiationAsked {
+		s.NegotiationAsked = true
+		m.StartNegotiation = true
+	}
+}
+
+// The exporter answers MODIFY_TEMPLATE with the templates it will use,
+// which may differ from what was asked for.
+func (e *Exporter) ModifyTemplateDone(m *ModifyTemplateResponse) {
+	sessId := m.Header.SessId
+
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	s, ok := e.sessions[sessId]
+	if !ok {
+		return
+	}
+	if len(m.Templates) > 0 {
+		// Only the modified templates are in the response.
+		for _, tb := range m.Templates {
+			nt := newTemplate(tb)
+			nt.Structs = s.Structures
+			e.validateTemplate(s.Id, nt)
+			replaceTemplate(s, nt)
+		}
+		s.setConfigId(m.ConfigID)
+	}
+	s.setState(SESS_TEMPLATE_RECEIVED)
+}
+
+func replaceTemplate(s *Session, nt *Template) {
+	for i, t := range s.Templates {
+		if t.TemplateID == nt.TemplateID {
+			s.Templates[i] = nt
+			s.rotateTemplate(t, nt)
+			return
+		}
+	}
+	s.Templates = append(s.Templates, nt)
+}
+
+// Rejection is not an error, the session streams the exporter templates.
+func (e *Exporter) NegotiationRejected(m *StartNegotiationReject) {
+	sessId := m.Header.SessId
+
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	if s, ok := e.sessions[sessId]; ok {
+		s.NegotiationAsked = true
+		if s.State == SESS_TEMPLATE_NEGOTIATING {
+			s.setState(SESS_TEMPLATE_RECEIVED)
+		}
+	}
+	e.logf("Template negotiation rejected for sess %d, keep exporter templates\n", sessId)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stellar/go-xdr/xdr"
+)
+
+// A DATA record decoded by its template. Values are typed by the field
+// type:
+//
+//	int, unsignedInt, long, unsignedLong     int32, uint32, int64, uint64
+//	byte, unsignedByte, short, unsignedShort int8, uint8, int16, uint16
+//	float, double, boolean                   float32, float64, bool
+//	string, hexBinary                        string, []byte
+//	dateTime, dateTimeMsec, dateTimeUsec     time.Time
+//	ipV4Addr, ipV6Addr, ipAddr               net.IP
+//	macAddress, UUID                         net.HardwareAddr, UUIDValue
+//	structure, array                         *StructValue, *ArrayValue
+type Record struct {
+	Template *Template
+	Fields   []FieldValue
+}
+
+type FieldValue struct {
+	Name  string
+	Type  TypeID
+	Value interface{}
+	Field *Field // template field, nil when encoding
+}
+
+type UUIDValue [16]byte
+
+func (u UUIDValue) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// Members of a structure, in definition order.
+type StructValue struct {
+	Structure *Structure
+	Fields    []FieldValue
+}
+
+type ArrayValue struct {
+	ElemType TypeID
+	Elems    []interface{}
+}
+
+// Value of a field by name, nil if the record has no such field.
+func (r *Record) Get(name string) interface{} {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			return r.Fields[i].Value
+		}
+	}
+	return nil
+}
+
+// Decode Data.Record, the record length then the enabled fields of the
+// template, which must take exactly the record. On error the fields decoded
+// so far are returned with it.
+func DecodeRecord(t *Template, data []byte) (*Record, error) {
+	rec := &Record{Template: t}
+	if len(data) < 4 {
+		return rec, fmt.Errorf("record length: %w", ErrMsgTruncated)
+	}
+	recordLen := binary.BigEndian.Uint32(data[:4])
+	input := data[4:]
+	if uint64(recordLen) != uint64(len(input)) {
+		return rec, fmt.Errorf("record length %d, %d bytes in DATA", recordLen, len(input))
+	}
+	for _, f := range t.Fields {
+		// Disabled fields are not sent.
+		if !f.IsEnabled {
+			continue
+		}
+		if TypeID(f.TypeID).IsComplex() && t.Structs == nil {
+			return rec, fmt.Errorf("field %s: %s without the structures capability",
+				f.FieldName, TypeID(f.TypeID))
+		}
+		v, length, err := decodeValue(TypeID(f.TypeID), input, t.Structs, 0)
+		if err != nil {
+			return rec, fmt.Errorf("field %s: %w", f.FieldName, err)
+		}
+		rec.Fields = append(rec.Fields, FieldValue{Name: f.FieldName, Type: TypeID(f.TypeID), Value: v, Field: f})
+		input = input[length:]
+	}
+	if len(input) > 0 {
+		return rec, fmt.Errorf("%d of %d bytes left after the last field", len(input), recordLen)
+	}
+	return rec, nil
+}
+
+func decodeValue(typeID TypeID, input []byte, structs Structures, depth int) (interface{}, uint32, error) {
+	if depth > maxStructureDepth {
+		return nil, 0, errStructureDepth
+	}
+
+	switch {
+	case typeID.IsArray():
+		if len(input) < 4 {
+			return nil, 0, fmt.Errorf("array count: %w", ErrMsgTruncated)
+		}
+		count := binary.BigEndian.Uint32(input[:4])
+		// Every element takes at least a byte, a larger count is garbage.
+		if uint64(count) > uint64(len(input)-4) {
+			return nil, 0, fmt.Errorf("array count %d: %w", count, ErrMsgTruncated)
+		}
+		a := &ArrayValue{ElemType: typeID.ElemType()}
+		offset := uint32(4)
+		for i := uint32(0); i < count; i++ {
+			v, length, err := decodeValue(a.ElemType, input[offset:], structs, depth+1)
+			if err != nil {
+				return nil, 0, fmt.Errorf("array element %d: %w", i, err)
+			}
+			a.Elems = append(a.Elems, v)
+			offset += length
+		}
+		return a, offset, nil
+
+	case typeID.IsStructure():
+		st, ok := structs[typeID.StructureID()]
+		if !ok {
+			return nil, 0, fmt.Errorf("undefined structure %d", typeID.StructureID())
+		}
+		sv := &StructValue{Structure: st}
+		offset := uint32(0)
+		for _, f := range st.Fields {
+			v, length, err := decodeValue(TypeID(f.TypeID), input[offset:], structs, depth+1)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s.%s: %w", st.Name, f.FieldName, err)
+			}
+			sv.Fields = append(sv.Fields, FieldValue{Name: f.FieldName, Type: TypeID(f.TypeID), Value: v})
+			offset += length
+		}
+		return sv, offset, nil
+	}
+
+	length, err := xdrBasicLength(typeID, input)
+	if err != nil {
+		return nil, 0, err
+	}
+	v, err := XdrValue(typeID, input[:length])
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, length, nil
+}
+
+// Typed value of a basic field, input starts with the field.
+func XdrValue(typeID TypeID, input []byte) (interface{}, error) {
+	length, err := xdrBasicLength(typeID, input)
+	if err != nil {
+		return nil, err
+	}
+	input = input[:length]
+	switch typeID {
+	case INT:
+		return int32(binary.BigEndian.Uint32(input)), nil
+	case UINT:
+		return binary.BigEndian.Uint32(input), nil
+	case LONG:
+		return int64(binary.BigEndian.Uint64(input)), nil
+	case ULONG:
+		return binary.BigEndian.Uint64(input), nil
+	case SHORT:
+		return int16(binary.BigEndian.Uint16(input)), nil
+	case USHORT:
+		return binary.BigEndian.Uint16(input), nil
+	case BYTE:
+		return int8(input[0]), nil
+	case UBYTE:
+		return input[0], nil
+	case BOOLEAN:
+		return input[0] != 0, nil
+	case FLOAT:
+		var f float32
+		_, err := xdr.Unmarshal(input, &f)
+		return f, err
+	case DOUBLE:
+		var d float64
+		_, err := xdr.Unmarshal(input, &d)
+		return d, err
+	case DATETIME:
+		return time.Unix(int64(binary.BigEndian.Uint32(input)), 0).UTC(), nil
+	case DATETIMEMSEC:
+		ms := int64(binary.BigEndian.Uint64(input))
+		return time.Unix(ms/1e3, (ms%1e3)*1e6).UTC(), nil
+	case DATETIMEUSEC:
+		us := int64(binary.BigEndian.Uint64(input))
+		return time.Unix(us/1e6, (us%1e6)*1e3).UTC(), nil
+	case HEXBINARY:
+		b := make([]byte, len(input)-4)
+		copy(b, input[4:])
+		return b, nil
+	case STRING:
+		return string(input[4:]), nil
+	case IPV4ADDR:
+		return net.IPv4(input[0], input[1], input[2], input[3]), nil
+	case IPV6ADDR:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, input[4:])
+		return ip, nil
+	case IPADDR:
+		length := binary.BigEndian.Uint32(input)
+		if length != net.IPv4len && length != net.IPv6len {
+			return nil, fmt.Errorf("ipAddr length %d", length)
+		}
+		ip := make(net.IP, length)
+		copy(ip, input[4:])
+		return ip, nil
+	case UUID:
+		var u UUIDValue
+		copy(u[:], input[4:])
+		return u, nil
+	case MACADDR:
+		mac := make(net.HardwareAddr, 6)
+		copy(mac, input[2:8])
+		return mac, nil
+	}
+	if def := lookupType(typeID); def != nil {
+		return def.Decode(input)
+	}
+	return nil, fmt.Errorf("unknown type %s", typeID)
+}
+
+// Text of a value as written to CSV. Times are the epoch count of their
+// type, MAC addresses in dotted form. The built in types keep the text of
+// the first releases, which parsers of the outputs rely on: signed
+// integers as their unsigned bit pattern, IPv6 addresses and UUIDs as
+// uint16 groups.
+func FormatValue(typeID TypeID, v interface{}) string {
+	if def := lookupType(typeID); def != nil && def.Format != nil {
+		return def.Format(v)
+	}
+	switch val := v.(type) {
+	case int8:
+		if typeID == BYTE {
+			return strconv.FormatUint(uint64(uint8(val)), 10)
+		}
+	case int16:
+		if typeID == SHORT {
+			return strconv.FormatUint(uint64(uint16(val)), 10)
+		}
+	case int32:
+		if typeID == INT {
+			return strconv.FormatUint(uint64(uint32(val)), 10)
+		}
+	case int64:
+		if typeID == LONG {
+			return strconv.FormatUint(uint64(val), 10)
+		}
+	case time.Time:
+		switch typeID {
+		case DATETIMEMSEC:
+			return strconv.FormatUint(uint64(val.Unix()*1e3+int64(val.Nanosecond()/1e6)), 10)
+		case DATETIMEUSEC:
+			return strconv.FormatUint(uint64(val.Unix()*1e6+int64(val.Nanosecond()/1e3)), 10)
+		}
+		return strconv.FormatInt(val.Unix(), 10)
+	case net.IP:
+		if typeID == IPV6ADDR || (typeID == IPADDR && len(val) == net.IPv6len) {
+			return formatUint16Groups(val.To16(), ":", ":")
+		}
+		return val.String()
+	case UUIDValue:
+		return formatUint16Groups(val[:], "", "-")
+	}
+
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case float32, float64:
+		return fmt.Sprintf("%f", val)
+	case bool:
+		return strconv.FormatBool(val)
+	case string:
+		return val
+	case []byte:
+		return fmt.Sprintf("[% x]", val)
+	case net.HardwareAddr:
+		return fmt.Sprintf("%02x%02x.%02x%02x.%02x%02x", val[0], val[1], val[2], val[3], val[4], val[5])
+	case *StructValue, *ArrayValue:
+		return formatJSON(typeID, v)
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+// The uint16 groups of b as "%02x", pairs joined by sep, pairs by pairSep.
+func formatUint16Groups(b []byte, sep, pairSep string) string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(b); i += 2 {
+		if i > 0 {
+			if i%4 == 0 {
+				sb.WriteString(pairSep)
+			} else {
+				sb.WriteString(sep)
+			}
+		}
+		fmt.Fprintf(&sb, "%02x", binary.BigEndian.Uint16(b[i:]))
+	}
+	return sb.String()
+}
+
+// JSON text of a value, for complex values in one column.
+func formatJSON(typeID TypeID, v interface{}) string {
+	switch val := v.(type) {
+	case *StructValue:
+		members := []string{}
+		for _, f := range val.Fields {
+			members = append(members, strconv.Quote(f.Name)+":"+formatJSON(f.Type, f.Value))
+		}
+		return "{" + strings.Join(members, ",") + "}"
+	case *ArrayValue:
+		elems := []string{}
+		for _, e := range val.Elems {
+			elems = append(elems, formatJSON(val.ElemType, e))
+		}
+		return "[" + strings.Join(elems, ",") + "]"
+	}
+	str := FormatValue(typeID, v)
+	if !isNumericType(typeID) {
+		str = strconv.Quote(str)
+	}
+	return str
+}
+
+// CSV columns of a field value, by the flatten policy.
+func formatColumns(typeID TypeID, v interface{}) []string {
+	if sv, ok := v.(*StructValue); ok && GetFlattenPolicy() == FLATTEN_COLUMNS {
+		cols := []string{}
+		for _, f := range sv.Fields {
+			cols = append(cols, formatColumns(f.Type, f.Value)...)
+		}
+		return cols
+	}
+	str := FormatValue(typeID, v)
+	if typeID.IsComplex() {
+		str = csvQuote(str)
+	}
+	return []string{str}
+}
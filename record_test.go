@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+)
+
+// Template with every kind of field, a structure and arrays.
+func testRecordTemplate() *Template {
+	structs := Structures{
+		1: {Id: 1, Name: "Member", Fields: []*Field{
+			{TypeID: uint32(UINT), FieldName: "Id", IsEnabled: true},
+			{TypeID: uint32(STRING), FieldName: "Name", IsEnabled: true},
+		}},
+	}
+	t := &Template{TemplateID: 1, TypeName: "TEST-TYPE", Structs: structs}
+	for i, typeID := range []TypeID{INT, UINT, LONG, ULONG, FLOAT, DOUBLE, HEXBINARY, STRING,
+		BOOLEAN, BYTE, UBYTE, SHORT, USHORT, DATETIME, DATETIMEMSEC, DATETIMEUSEC,
+		IPV4ADDR, IPV6ADDR, IPADDR, UUID, MACADDR,
+		TYPE_STRUCTURE_FLAG | 1, TYPE_ARRAY_FLAG | STRING, TYPE_ARRAY_FLAG | TYPE_STRUCTURE_FLAG | 1} {
+		t.Fields = append(t.Fields, &Field{TypeID: uint32(typeID), FieldID: uint32(i), FieldName: typeID.String(), IsEnabled: true})
+	}
+	return t
+}
+
+func FuzzDecodeRecord(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 6, 0xff, 0xff, 0xff, 0xfe, 0, 0})
+	f.Add([]byte{0, 0, 0, 4, 0, 0, 0, 1})
+	f.Add([]byte{0, 0, 0, 8, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, tp := range []*Template{testRecordTemplate(), {Fields: []*Field{
+			{TypeID: uint32(STRING), FieldName: "String", IsEnabled: true},
+			{TypeID: uint32(HEXBINARY), FieldName: "HexBinary", IsEnabled: true},
+			{TypeID: uint32(IPADDR), FieldName: "IpAddr", IsEnabled: true},
+		}}} {
+			rec, err := DecodeRecord(tp, data)
+			if err != nil {
+				continue
+			}
+			// A decoded record encodes and decodes again.
+			b, err := EncodeRecord(rec)
+			if err != nil {
+				t.Fatalf("encode decoded record: %v", err)
+			}
+			if _, err = DecodeRecord(tp, b); err != nil {
+				t.Fatalf("decode encoded record: %v", err)
+			}
+		}
+	})
+}
+
+// CSV text of the built in types is the one of the first releases.
+func TestFormatValueBaseline(t *testing.T) {
+	cases := []struct {
+		typeID TypeID
+		wire   []byte
+		text   string
+	}{
+		{INT, []byte{0xff, 0xff, 0xff, 0xfe}, "4294967294"},
+		{SHORT, []byte{0xff, 0xfe}, "65534"},
+		{LONG, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "18446744073709551615"},
+		{BYTE, []byte{0xff}, "255"},
+		{DATETIMEMSEC, []byte{0xff, 0, 0, 0, 0, 0, 0, 1}, "18374686479671623681"},
+		{DATETIMEUSEC, []byte{0, 0x06, 0x0a, 0x24, 0x18, 0x20, 0x22, 0x40}, "1700000000123456"},
+		{IPV6ADDR, []byte{0, 0, 0, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+			"2001:db8:00:00:00:00:00:01"},
+		{IPV6ADDR, []byte{0, 0, 0, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1},
+			"00:00:00:00:00:ffff:a00:01"},
+		{IPADDR, []byte{0, 0, 0, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2},
+			"2001:db8:00:00:00:00:00:02"},
+		{IPADDR, []byte{0, 0, 0, 4, 192, 168, 1, 2}, "192.168.1.2"},
+		{IPV4ADDR, []byte{10, 0, 0, 1}, "10.0.0.1"},
+		{UUID, []byte{0, 0, 0, 16, 0x01, 0x02, 0x00, 0x04, 0x9a, 0xbc, 0xde, 0xf0,
+			0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, "10204-9abcdef0-1234567-89abcdef"},
+		{MACADDR, []byte{0, 0, 0, 0x11, 0x22, 0x33, 0x44, 0x55}, "0011.2233.4455"},
+		{BOOLEAN, []byte{2}, "true"},
+		{FLOAT, []byte{0x3f, 0xc0, 0, 0}, "1.500000"},
+		{HEXBINARY, []byte{0, 0, 0, 2, 0xde, 0xad}, "[de ad]"},
+	}
+	for _, c := range cases {
+		text, err := XdrDecode(c.typeID, c.wire)
+		if err != nil {
+			t.Errorf("%s: %v", c.typeID, err)
+			continue
+		}
+		if text != c.text {
+			t.Errorf("%s: %q, want %q", c.typeID, text, c.text)
+		}
+	}
+}
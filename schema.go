@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IPDR service definition XSDs, loaded from the collector "schema-dir" to
+// check the templates of TEMPLATE_DATA and to add units and documentation
+// to their fields. Names are compared without namespace prefix.
+type xsdAnnotation struct {
+	Documentation []string `xml:"documentation"`
+	Units         string   `xml:"appinfo>units"`
+	Reference     string   `xml:"appinfo>reference"`
+}
+
+type xsdEnumeration struct {
+	Value      string        `xml:"value,attr"`
+	Annotation xsdAnnotation `xml:"annotation"`
+}
+
+type xsdRestriction struct {
+	Base         string           `xml:"base,attr"`
+	Enumerations []xsdEnumeration `xml:"enumeration"`
+}
+
+type xsdSimpleType struct {
+	Name        string         `xml:"name,attr"`
+	Restriction xsdRestriction `xml:"restriction"`
+}
+
+type xsdElement struct {
+	Name       string         `xml:"name,attr"`
+	Ref        string         `xml:"ref,attr"`
+	Type       string         `xml:"type,attr"`
+	MinOccurs  string         `xml:"minOccurs,attr"`
+	SimpleType *xsdSimpleType `xml:"simpleType"`
+	Annotation xsdAnnotation  `xml:"annotation"`
+}
+
+type xsdComplexType struct {
+	Name     string       `xml:"name,attr"`
+	Sequence []xsdElement `xml:"sequence>element"`
+	Extended []xsdElement `xml:"complexContent>extension>sequence>element"`
+}
+
+type xsdSchema struct {
+	TargetNamespace string           `xml:"targetNamespace,attr"`
+	Elements        []xsdElement     `xml:"element"`
+	ComplexTypes    []xsdComplexType `xml:"complexType"`
+	SimpleTypes     []xsdSimpleType  `xml:"simpleType"`
+}
+
+type SchemaEnum struct {
+	Value string
+	Name  string
+}
+
+type SchemaField struct {
+	Name          string
+	Type          string // XSD or IPDR type name, e.g. unsignedInt, ipV4Addr
+	Optional      bool
+	Units         string
+	Documentation string
+	Enums         []SchemaEnum
+}
+
+type SchemaType struct {
+	Name      string
+	Namespace string
+	FileName  string
+	Fields    []*SchemaField
+	Builtin   bool // from the DOCSIS catalog, not checked
+}
+
+// Types are kept by target namespace, the same type name may be declared
+// by several service definitions.
+type SchemaCatalog struct {
+	types    map[string][]*SchemaType // by bare name, all namespaces
+	byNs     map[string][]*SchemaType
+	elements map[string]*xsdElement
+	simple   map[string]*xsdSimpleType
+}
+
+var schemaCatalog *SchemaCatalog
+
+func localName(name string) string {
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func (a *xsdAnnotation) doc() string {
+	return strings.Join(strings.Fields(strings.Join(a.Documentation, " ")), " ")
+}
+
+// Load every .xsd of dir, type references may cross files.
+func LoadSchemas(dir string) (*SchemaCatalog, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xsd"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .xsd in %s", dir)
+	}
+
+	c := &SchemaCatalog{
+		types:    make(map[string][]*SchemaType),
+		byNs:     make(map[string][]*SchemaType),
+		elements: make(map[string]*xsdElement),
+		simple:   make(map[string]*xsdSimpleType),
+	}
+	// Files in name order, so the loaded catalog does not vary from run to run.
+	schemas := make([]*xsdSchema, len(files))
+	for i, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		xs := &xsdSchema{}
+		if err = xml.Unmarshal(b, xs); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		schemas[i] = xs
+		for i := range xs.Elements {
+			c.elements[xs.Elements[i].Name] = &xs.Elements[i]
+		}
+		for i := range xs.SimpleTypes {
+			c.simple[xs.SimpleTypes[i].Name] = &xs.SimpleTypes[i]
+		}
+	}
+
+	for i, xs := range schemas {
+		for _, ct := range xs.ComplexTypes {
+			st := &SchemaType{
+				Name:      ct.Name,
+				Namespace: xs.TargetNamespace,
+				FileName:  filepath.Base(files[i]),
+			}
+			for _, el := range append(ct.Sequence, ct.Extended...) {
+				st.Fields = append(st.Fields, c.resolveField(el))
+			}
+			c.add(st)
+		}
+	}
+	return c, nil
+}
+
+func (c *SchemaCatalog) add(st *SchemaType) {
+	c.types[st.Name] = append(c.types[st.Name], st)
+	c.byNs[st.Namespace] = append(c.byNs[st.Namespace], st)
+}
+
+func (c *SchemaCatalog) NumTypes() int {
+	n := 0
+	for _, types := range c.byNs {
+		n += len(types)
+	}
+	return n
+}
+
+// Field of an element of a type sequence, a ref is looked up in the
+// global elements.
+func (c *SchemaCatalog) resolveField(el xsdElement) *SchemaField {
+	optional := el.MinOccurs == "0"
+	if el.Ref != "" {
+		if g, ok := c.elements[localName(el.Ref)]; ok {
+			el = *g
+		} else {
+			el.Name = localName(el.Ref)
+		}
+	}
+
+	sf := &SchemaField{
+		Name:          el.Name,
+		Optional:      optional,
+		Units:         el.Annotation.Units,
+		Documentation: el.Annotation.doc(),
+	}
+	simple := el.SimpleType
+	typeName := el.Type
+	// Named simple types are restrictions of a base type, maybe nested.
+	for i := 0; i < maxStructureDepth; i++ {
+		if simple == nil {
+			if s, ok := c.simple[localName(typeName)]; ok {
+				simple = s
+			} else {
+				break
+			}
+		}
+		if len(sf.Enums) == 0 {
+			for _, e := range simple.Restriction.Enumerations {
+				sf.Enums = append(sf.Enums, SchemaEnum{Value: e.Value, Name: e.Annotation.doc()})
+			}
+		}
+		typeName = simple.Restriction.Base
+		simple = nil
+	}
+	sf.Type = localName(typeName)
+	return sf
+}
+
+// Namespace of the catalog the schema name is in, the longest one it
+// starts with, as a whole path segment.
+func (c *SchemaCatalog) namespace(schemaName string) (string, bool) {
+	best, found := "", false
+	for ns := range c.byNs {
+		if ns == "" || !strings.HasPrefix(schemaName, ns) || len(ns) < len(best) {
+			continue
+		}
+		if rest := schemaName[len(ns):]; rest == "" || strings.ContainsRune("/#", rune(rest[0])) {
+			best, found = ns, true
+		}
+	}
+	return best, found
+}
+
+// Find the schema type of a template. Within the namespace of the schema
+// name by type name, or its only type. Else by type name when only one
+// namespace declares it.
+func (c *SchemaCatalog) Lookup(schemaName, typeName string) *SchemaType {
+	name := localName(typeName)
+	names := []string{name, name + "-type", name + "_type", name + "Type"}
+	if ns, ok := c.namespace(schemaName); ok {
+		types := c.byNs[ns]
+		for _, n := range names {
+			for _, st := range types {
+				if st.Name == n {
+					return st
+				}
+			}
+		}
+		if len(types) == 1 {
+			return types[0]
+		}
+		return nil
+	}
+	for _, n := range names {
+		if types := c.types[n]; len(types) == 1 {
+			return types[0]
+		}
+	}
+	return nil
+}
+
+// Check a template against its schema type and add the schema metadata to
+// its fields. Returns the problems found.
+func (c *SchemaCatalog) Validate(t *Template) []string {
+	st := c.Lookup(t.SchemaName, t.TypeName)
+	if st == nil {
+		return []string{fmt.Sprintf("unknown schema %s type %s", t.SchemaName, t.TypeName)}
+	}
+	t.Schema = st
+
+	warnings := []string{}
+	fields := make(map[string]*SchemaField)
+	for _, sf := range st.Fields {
+		fields[sf.Name] = sf
+	}
+	seen := make(map[string]bool)
+	for _, f := range t.Fields {
+		name := localName(f.FieldName)
+		seen[name] = true
+		sf, ok := fields[name]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("unknown field %s", f.FieldName))
+			continue
+		}
+		f.Schema = sf
+		if !TypeID(f.TypeID).IsComplex() && TypeID(f.TypeID).String() != sf.Type && sf.Type != "" {
+			warnings = append(warnings, fmt.Sprintf("mistyped field %s: %s, schema %s",
+				f.FieldName, TypeID(f.TypeID), sf.Type))
+		}
+	}
+	for _, sf := range st.Fields {
+		if !seen[sf.Name] && !sf.Optional {
+			warnings = append(warnings, fmt.Sprintf("missing field %s", sf.Name))
+		}
+	}
+	return warnings
+}
+
+// An XSD type takes precedence over the built in catalog. Must hold
+// sessionMutex.
+func (e *Exporter) validateTemplate(sessId byte, t *Template) {
+	if schemaCatalog == nil || schemaCatalog.Lookup(t.SchemaName, t.TypeName) == nil {
+		if docsisCatalog.Annotate(t) || schemaCatalog == nil {
+			return
+		}
+	}
+	for _, w := range schemaCatalog.Validate(t) {
+		e.logf("Warning: sess %d template %d %s: %s\n", sessId, t.TemplateID, t.TypeName, w)
+	}
+}
+
+// Field metadata from the schema next to an output, <output>.fields.csv.
+func writeFieldsFile(t *Template) {
+	if t.Schema == nil || t.Schema.Builtin {
+		return
+	}
+	file, err := os.Create(t.FileName + ".fields.csv")
+	if err != nil {
+		log.Printf("writeFieldsFile err: %s\n", err)
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "Field,Type,Units,Documentation\n")
+	for _, f := range t.Fields {
+		if !f.IsEnabled {
+			continue
+		}
+		units, doc := "", ""
+		if f.Schema != nil {
+			units, doc = f.Schema.Units, f.Schema.Documentation
+		}
+		fmt.Fprintf(file, "%s,%s,%s,%s\n", csvQuote(f.FieldName), TypeID(f.TypeID),
+			csvQuote(units), csvQuote(doc))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testXsd = `<?xml version="1.0" encoding="UTF-8"?>
+<schema xmlns="http://www.w3.org/2001/XMLSchema" targetNamespace="%s">
+  <complexType name="DOCSIS-Type">
+    <sequence>
+      <element name="%s" type="unsignedInt">
+        <annotation><appinfo><units>%s</units></appinfo></annotation>
+      </element>
+    </sequence>
+  </complexType>
+</schema>
+`
+
+func TestSchemaSameTypeName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const nsA = "http://www.cablelabs.com/namespaces/DOCSIS/3.0/xsd/ipdr/A"
+	const nsB = "http://www.cablelabs.com/namespaces/DOCSIS/3.0/xsd/ipdr/AB"
+	for file, xsd := range map[string]string{
+		"a.xsd": fmt.Sprintf(testXsd, nsA, "CmCount", "a"),
+		"b.xsd": fmt.Sprintf(testXsd, nsB, "CpeCount", "b"),
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, file), []byte(xsd), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := LoadSchemas(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := c.NumTypes(); n != 2 {
+		t.Fatalf("%d types, want 2", n)
+	}
+
+	for _, tc := range []struct {
+		schemaName string
+		field      string
+		units      string
+	}{
+		{nsA, "CmCount", "a"},
+		{nsA + "/A_3.5.1.xsd", "CmCount", "a"},
+		{nsB, "CpeCount", "b"},
+		{nsB + "/AB_3.5.1.xsd", "CpeCount", "b"},
+	} {
+		tp := &Template{SchemaName: tc.schemaName, TypeName: "DOCSIS-Type", Fields: []*Field{
+			{TypeID: uint32(UINT), FieldName: tc.field, IsEnabled: true},
+		}}
+		if w := c.Validate(tp); len(w) != 0 {
+			t.Errorf("%s: warnings %v", tc.schemaName, w)
+			continue
+		}
+		if tp.Fields[0].Schema == nil || tp.Fields[0].Schema.Units != tc.units {
+			t.Errorf("%s: field %s not of the namespace type", tc.schemaName, tc.field)
+		}
+	}
+
+	// The bare name is ambiguous without a known namespace.
+	if st := c.Lookup("", "DOCSIS-Type"); st != nil {
+		t.Errorf("bare name found %s type", st.Namespace)
+	}
+	if st := c.Lookup(nsA+"X", "DOCSIS-Type"); st != nil {
+		t.Errorf("namespace prefix found %s type", st.Namespace)
+	}
+}
@@ -2,27 +2,21 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"sync"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-var (
-	msgChan                     = make(chan IPDRMsg)
-	sessions                    = make(map[byte]*Session)
-	sessionMutex   sync.RWMutex = sync.RWMutex{}
-	kaSendInterval uint32       = 300
-	kaRecvInterval uint32       = 300 //default 300 seconds
-	lastKaSendTime time.Time
-)
-
 type Field struct {
 	TypeID    uint32
 	FieldID   uint32
 	FieldName string
 	IsEnabled bool
+	Schema    *SchemaField
 }
 
 type Template struct {
@@ -32,58 +26,104 @@ type Template struct {
 	Fields     []*Field
 	FileName   string
 	Output     *os.File
+	// Duplicate flagged records, by the session dedup policy.
+	MarkDuplicates bool
+	DupFileName    string
+	DupOutput      *os.File
+	// Written since the last sync.
+	Dirty bool
+	// Structure definitions of the session, for complex fields. Nil when
+	// the structures capability was not agreed.
+	Structs      Structures
+	LayoutErrors uint64
+	Schema       *SchemaType
+	Enums        EnumMode
+}
+
+// Templates reported by GET_TEMPLATES_RESPONSE, kept per session id even
+// when no flow is started for it.
+type TemplateSet struct {
+	SessId    byte
+	ConfigId  uint16
+	Templates []*Template
+	Updated   time.Time
 }
 
 type Session struct {
 	Id                  byte
-	Type                byte
+	Name                string
+	Description         string
+	Type                SessionType
 	AckSequenceInterval uint32
 	AckTimeInterval     uint32
 	ConfigId            uint16
 	UnackedNum          uint32
 	LastSeq             uint64
 	LastAckedTime       time.Time
-	Started             bool
+	State               SessState
 	DocID               []byte
+	Document            *Document
 	Templates           []*Template
+	Structures          Structures
+	Versions            map[uint16][]*Template // templates by ConfigID
+	Quarantine          *os.File
+	QuarantineDirty     bool
+	NegotiationAsked    bool
+	Stats               SessionStats
+	Primary             bool // a secondary neither writes nor acks
+	RoleKnown           bool
+	RoleChanges         uint64
+	Resuming            bool // skip records up to ResumeSeq, already stored
+	ResumeSeq           uint64
+	StoredSeq           uint64 // highest seq written, not yet synced
+	DurableSeq          uint64 // highest seq synced to the outputs
+	AckedSeq            uint64
+	WriteFailed         bool
+	exporter            *Exporter
 }
 
-func (t *Template) appendRecord(r []byte) error {
-	var length uint32
-	var typeId TypeID
+func (t *Template) appendRecord(rec *Record, dup bool) error {
 	var err error
 	first := true
-	r = r[4:]
-	bufferedWriter := bufio.NewWriter(t.Output)
-	for _, f := range t.Fields {
-		typeId = TypeID(f.TypeID)
-		length = XdrTypeLength(typeId, r)
-		str, _ := XdrDecode(typeId, r[:length])
-		if first {
-			_, err = bufferedWriter.WriteString(str)
-			first = false
-		} else {
-			_, err = bufferedWriter.WriteString("," + str)
+	output := t.Output
+	if dup && t.DupOutput != nil {
+		output = t.DupOutput
+	}
+	if output == nil {
+		return fmt.Errorf("template %d has no output", t.TemplateID)
+	}
+	bufferedWriter := bufio.NewWriter(output)
+	for i := range rec.Fields {
+		fv := &rec.Fields[i]
+		for _, str := range t.enumColumns(fv, formatColumns(fv.Type, fv.Value)) {
+			if first {
+				_, err = bufferedWriter.WriteString(str)
+				first = false
+			} else {
+				_, err = bufferedWriter.WriteString("," + str)
+			}
 		}
 		if err != nil {
 			log.Printf("appendRecord err: %s\n", err)
 			break
 		}
-		//log.Printf("F.ID %x F.FieldID %d, F.Name %s, length %d, r: % x, str %s\n",
-		//	f.TypeID, f.FieldID, f.FieldName, length, r[:length], str)
-		//log.Printf("remain len %d, len %d\n", len(r), length)
-		if uint32(len(r)) > length {
-			r = r[length:]
+	}
+	if t.MarkDuplicates {
+		if dup {
+			bufferedWriter.WriteString(",1")
 		} else {
-			break
+			bufferedWriter.WriteString(",0")
 		}
 	}
 	_, err = bufferedWriter.WriteString("\n")
+	if err == nil {
+		err = bufferedWriter.Flush()
+	}
 	if err != nil {
 		log.Printf("appendRecord err: %s\n", err)
+		return err
 	}
-	bufferedWriter.Flush()
-	bufferedWriter.Reset(bufferedWriter)
+	t.Dirty = true
 	return nil
 }
 
@@ -91,9 +131,15 @@ func (s *Session) sendAck() {
 
 	s.UnackedNum = 0
 	s.LastAckedTime = time.Now()
-	msg := NewDataAckMsg(s.ConfigId, s.Id, s.LastSeq)
-	log.Printf("Send %s\n", msg.Desc())
-	SendMsgToExporter(msg.Encode())
+	seq, ok := s.ackSeq()
+	if !ok {
+		return
+	}
+	msg := NewDataAckMsg(s.ConfigId, s.Id, seq)
+	s.exporter.logf("Send %s\n", msg.Desc())
+	s.exporter.SendMsgToExporter(msg.Encode())
+	s.AckedSeq = seq
+	s.Checkpoint()
 }
 
 func (s *Session) CheckSequenceInterval() {
@@ -108,221 +154,681 @@ func (s *Session) CheckAckTimeInterval() {
 	}
 }
 
-func UpdateLastKaSendTime() {
-	lastKaSendTime = time.Now()
+func (e *Exporter) UpdateLastKaSendTime() {
+	e.kaMutex.Lock()
+	defer e.kaMutex.Unlock()
+	e.lastKaSendTime = time.Now()
 }
 
-var kaRecvTimer = time.NewTimer(time.Second * time.Duration(kaRecvInterval))
+func (e *Exporter) UpdateLastKaRcvdTime() {
+	e.kaMutex.Lock()
+	defer e.kaMutex.Unlock()
+	e.kaRecvTimer.Reset(time.Second * time.Duration(e.kaRecvInterval))
+}
 
-func UpdateLastKaRcvdTime() {
-	kaRecvTimer.Reset(time.Second * time.Duration(kaRecvInterval))
+func (e *Exporter) getKaRecvInterval() uint32 {
+	e.kaMutex.Lock()
+	defer e.kaMutex.Unlock()
+	return e.kaRecvInterval
 }
 
-func SetKaRecvInterval(ka uint32) {
-	kaRecvInterval = ka + 2
-	log.Printf("Set KA recv interval to %d\n", ka)
+func (e *Exporter) SetKaRecvInterval(ka uint32) {
+	e.kaMutex.Lock()
+	e.kaRecvInterval = ka + 2
+	e.kaMutex.Unlock()
+	e.logf("Set KA recv interval to %d\n", ka)
 }
 
-func CheckKeepAliveInterval() {
+func (e *Exporter) CheckKeepAliveInterval() {
+	e.kaMutex.Lock()
+	due := time.Since(e.lastKaSendTime) >= time.Duration(e.kaSendInterval)*time.Second
+	e.kaMutex.Unlock()
 	//Send KA
-	if time.Since(lastKaSendTime) >= time.Duration(kaSendInterval)*time.Second {
-		UpdateLastKaSendTime()
+	if due {
+		e.UpdateLastKaSendTime()
 		msg := NewKeepAliveMsg()
-		log.Printf("Send %s\n", msg.Desc())
-		SendMsgToExporter(msg.Encode())
+		e.logf("Send %s\n", msg.Desc())
+		e.SendMsgToExporter(msg.Encode())
 	}
 
 }
 
-func AddSession(m *TemplateData) {
+func (e *Exporter) AddSession(m *TemplateData) {
 	sessId := m.Header.SessId
 
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	s, ok := e.sessions[sessId]
+	if !ok {
+		s = e.newSession(sessId)
+		e.sessions[sessId] = s
+	}
+	// Without the structures capability complex fields are layout errors.
+	s.Structures = nil
+	if e.HasCapability(CAP_STRUCTURES) {
+		if err := m.DecodeStructures(); err != nil {
+			e.logf("Sess %d decode structures: %s\n", sessId, err)
+		}
+		s.Structures = newStructures(m.Structures)
+	} else if len(m.structureData) > 0 {
+		e.logf("Warning: sess %d %d bytes after the templates, structures not agreed\n",
+			sessId, len(m.structureData))
+	}
+	e.setTemplates(s, m.ConfigID, m.Templates)
+
+	//log.Printf("Add session % +v\n", s)
+
+	s.setState(SESS_TEMPLATE_RECEIVED)
+}
+
+func (e *Exporter) setTemplates(s *Session, configId uint16, templates []TemplateBlock) {
+	old := s.Templates
+	s.Templates = nil
+
+	for _, tb := range templates {
+		t := newTemplate(tb)
+		t.Structs = s.Structures
+		e.validateTemplate(s.Id, t)
+		e.logf("set sess %d template %d name to null", s.Id, t.TemplateID)
+		s.Templates = append(s.Templates, t)
+	}
+	for _, ot := range old {
+		if nt := s.findTemplate(ot.TemplateID); nt != nil {
+			s.rotateTemplate(ot, nt)
+		} else {
+			closeTemplateFiles(ot)
+		}
+	}
+	s.setConfigId(configId)
+}
+
+func newTemplate(tb TemplateBlock) *Template {
+	t := &Template{}
+	t.TemplateID = tb.TemplateID
+	t.SchemaName = string(tb.SchemaName.Str)
+	t.TypeName = string(tb.TypeName.Str)
+	t.Output = nil
+	t.FileName = ""
+	for _, fd := range tb.Fields {
+		f := &Field{}
+		f.TypeID = fd.TypeID
+		f.FieldID = fd.FieldID
+		f.FieldName = string(fd.FieldName.Str)
+		if fd.IsEnabled == 0 {
+			f.IsEnabled = false
+		} else {
+			f.IsEnabled = true
+		}
+		t.Fields = append(t.Fields, f)
+	}
+	return t
+}
 
-	s := &Session{
+func (e *Exporter) newSession(sessId byte) *Session {
+	return &Session{
 		Id:       sessId,
-		ConfigId: m.ConfigID,
+		State:    SESS_FLOW_STARTED,
+		exporter: e,
 	}
+}
 
-	for _, tb := range m.Templates {
-		t := &Template{}
-		t.TemplateID = tb.TemplateID
-		t.SchemaName = string(tb.SchemaName.Str)
-		t.TypeName = string(tb.TypeName.Str)
-		t.Output = nil
-		t.FileName = ""
-		log.Printf("set sess %d template %d name to null", sessId, t.TemplateID)
-		for _, fd := range tb.Fields {
-			f := &Field{}
-			f.TypeID = fd.TypeID
-			f.FieldID = fd.FieldID
-			f.FieldName = string(fd.FieldName.Str)
-			if fd.IsEnabled == 0 {
-				f.IsEnabled = false
-			} else {
-				f.IsEnabled = true
+// FLOW_START is sent for the sessions, TEMPLATE_DATA is expected next.
+func (e *Exporter) StartFlows(sessIds []byte) {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	for _, id := range sessIds {
+		s := e.newSession(id)
+		// Advertised values, SESSION_START may override them.
+		for _, b := range e.advertisedSessions {
+			if b.SessId == id {
+				s.Name = string(b.SessName.Str)
+				s.Description = string(b.SessDesc.Str)
+				s.AckTimeInterval = b.AckTimeInterval
+				s.AckSequenceInterval = b.AckSequenceInterval
+				break
 			}
-			t.Fields = append(t.Fields, f)
 		}
-		s.Templates = append(s.Templates, t)
+		s.Type = e.getSessionType(s)
+		e.sessions[id] = s
+		e.logf("Sess %d \"%s\" %s state %s, ack interval %ds / %d records\n",
+			id, s.Name, s.Type, SESS_FLOW_STARTED, s.AckTimeInterval, s.AckSequenceInterval)
 	}
+}
 
-	//log.Printf("Add session % +v\n", s)
+// Exporter name as part of a file name, anything but [0-9A-Za-z.-] becomes '-'.
+func fileNameSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') ||
+			(r >= 'A' && r <= 'Z') || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+}
 
-	sessions[m.Header.SessId] = s
+// Create an output file, never one already there: it may hold acked
+// records. A name in use gets a "-<n>" suffix.
+func createOutputFile(fileName string) (*os.File, string, error) {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return file, fileName, err
+		}
+		fileName = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
 }
 
+// Files are named by the document start, for time-interval sessions the
+// start of the collection interval, and the DocumentID.
 func createFileTemplate(t *Template, s *Session) {
-	fileName := fmt.Sprintf("IPDR_RECORD_%s_S%d_T%d_%s.csv",
-		time.Now().Format("2006-01-02-15-04-05"), s.Id, t.TemplateID, t.TypeName)
-	file, err := os.Create(fileName)
+	start := time.Now()
+	if s.Document != nil {
+		start = s.Document.Start
+		// A template redefined within the document gets a file of its own.
+		if _, ok := s.Document.Files[t.TemplateID]; ok {
+			start = time.Now()
+		}
+	}
+	fileName := fmt.Sprintf("IPDR_RECORD_%s_%s_%x_S%d_T%d_%s.csv",
+		fileNameSafe(s.exporter.Name), start.Format("2006-01-02-15-04-05"),
+		s.DocID, s.Id, t.TemplateID, t.TypeName)
+	file, fileName, err := createOutputFile(fileName)
 	if err != nil {
-		log.Printf("Err: %s\n", err)
+		s.exporter.logf("Err: %s\n", err)
 		return
 	}
 	t.FileName = fileName
-	log.Printf("set sess %d template %d name to %s", s.Id, t.TemplateID, fileName)
+	s.exporter.logf("set sess %d template %d name to %s", s.Id, t.TemplateID, fileName)
 	t.Output = file
+	writeFileHeader(t, file)
+	writeFieldsFile(t)
+	// Only the current templates are resumed with the document.
+	if s.Document != nil && s.findTemplate(t.TemplateID) == t {
+		s.Document.Files[t.TemplateID] = DocumentFile{FileName: fileName, Schema: t.schema()}
+	}
+}
+
+func writeFileHeader(t *Template, file *os.File) {
+	var err error
 	bufferedWriter := bufio.NewWriter(file)
 	first := true
 	for _, f := range t.Fields {
-		if first {
-			_, err = bufferedWriter.WriteString(f.FieldName)
-			first = false
-		} else {
-			_, err = bufferedWriter.WriteString("," + f.FieldName)
+		// Disabled fields are not sent.
+		if !f.IsEnabled {
+			continue
+		}
+		for _, name := range t.enumHeader(f, headerColumns(f.FieldName, TypeID(f.TypeID), t.Structs, 0)) {
+			if first {
+				_, err = bufferedWriter.WriteString(name)
+				first = false
+			} else {
+				_, err = bufferedWriter.WriteString("," + name)
+			}
 		}
 	}
+	if t.MarkDuplicates {
+		_, err = bufferedWriter.WriteString(",Duplicate")
+	}
 	_, err = bufferedWriter.WriteString("\n")
+	if err != nil {
+		log.Printf("writeFileHeader err: %s\n", err)
+	}
 	bufferedWriter.Flush()
 	bufferedWriter.Reset(bufferedWriter)
+}
 
+// Side file for duplicate flagged records, created on the first one.
+func createDupFileTemplate(t *Template, s *Session) {
+	fileName := fmt.Sprintf("IPDR_DUPLICATE_%s_%s_%x_S%d_T%d_%s.csv",
+		fileNameSafe(s.exporter.Name), time.Now().Format("2006-01-02-15-04-05"),
+		s.DocID, s.Id, t.TemplateID, t.TypeName)
+	file, fileName, err := createOutputFile(fileName)
+	if err != nil {
+		s.exporter.logf("Err: %s\n", err)
+		return
+	}
+	t.DupFileName = fileName
+	s.exporter.logf("set sess %d template %d duplicate name to %s", s.Id, t.TemplateID, fileName)
+	t.DupOutput = file
+	writeFileHeader(t, file)
 }
 
-func createFiles(s *Session) {
+// Open the outputs of a session document, a resumed document appends to
+// its files.
+func createFiles(s *Session, resumed bool) {
+	policy := s.exporter.getDedupConfig(s.Id).GetPolicy()
 	for _, t := range s.Templates {
+		t.MarkDuplicates = policy == DEDUP_MARK
+		t.Enums = s.exporter.getEnumMode(s.Id, t)
+		if resumed && openFileTemplate(t, s) {
+			continue
+		}
 		createFileTemplate(t, s)
 	}
 }
 
-func closeFiles(s *Session) {
-	for _, t := range s.Templates {
+func closeTemplateFiles(t *Template) {
+	if t.Output != nil {
+		t.Output.Sync()
 		t.Output.Close()
-		t.FileName = ""
+		t.Output = nil
+	}
+	if t.DupOutput != nil {
+		t.DupOutput.Sync()
+		t.DupOutput.Close()
+		t.DupOutput = nil
+	}
+	t.FileName = ""
+	t.DupFileName = ""
+	t.Dirty = false
+}
+
+func closeFiles(s *Session) {
+	for _, t := range s.outputTemplates() {
+		closeTemplateFiles(t)
 	}
+	s.closeQuarantine()
 }
 
-func StartSession(m *SessionStart) {
+func (e *Exporter) StartSession(m *SessionStart) {
 	sessId := m.Header.SessId
 
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
 
-	if s, ok := sessions[sessId]; ok {
-		s.Type = 0
+	if s, ok := e.sessions[sessId]; ok {
 		s.AckSequenceInterval = m.AckSequenceInterval
 		s.AckTimeInterval = m.AckTimeInterval
 		s.UnackedNum = 0
 		s.LastSeq = 0
+		s.StoredSeq = 0
+		s.DurableSeq = 0
+		s.AckedSeq = 0
+		s.WriteFailed = false
 		s.LastAckedTime = time.Now()
-		s.Started = true
+		s.startStats(m)
+		s.setState(SESS_STARTED)
 		s.DocID = make([]byte, 16)
 		copy(s.DocID, m.DocumentID)
-		createFiles(s)
+		s.setRole(m.Primary != 0)
+		s.resumeFromCheckpoint(m)
+		doc, resumed := e.startDocument(s, m)
+		s.Document = doc
+		if s.Primary {
+			createFiles(s, resumed)
+		}
 	} else {
-		log.Printf("Session %d not exist internal when handle start session.\n", sessId)
+		e.logf("Session %d not exist internal when handle start session.\n", sessId)
 
 	}
 }
 
-func UpdateSession(d *Data) {
+func (e *Exporter) UpdateSession(d *Data) {
 	sessId := d.Header.SessId
-	if s, ok := sessions[sessId]; ok {
-		sessions[sessId].LastSeq = d.SequenceNum
-		sessions[sessId].ConfigId = d.ConfigID
-		sessions[sessId].UnackedNum++
-		sessions[sessId].CheckSequenceInterval()
-		for _, t := range s.Templates {
-			if t.TemplateID == d.TemplateID {
-				t.appendRecord(d.Record)
-				break
+	// Already acked the last record on shutdown, anything later is replayed.
+	if e.stopping {
+		return
+	}
+
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	if s, ok := e.sessions[sessId]; ok {
+		status := s.checkSequence(d.SequenceNum)
+		if d.SequenceNum > s.LastSeq || status == SEQ_REGRESSION {
+			s.LastSeq = d.SequenceNum
+		}
+		// Secondary, keep the sequence state warm for a promotion.
+		if !s.Primary {
+			return
+		}
+		// After a write failure nothing more is stored or acked, the
+		// exporter keeps the records.
+		if !s.WriteFailed {
+			if err := e.storeRecord(s, d); err != nil {
+				s.writeFailed(err)
+			} else {
+				s.recordStored(d.SequenceNum, status)
+			}
+		}
+		s.UnackedNum++
+		s.CheckSequenceInterval()
+	}
+}
+
+// Write a DATA record to the session outputs. Records skipped as already
+// stored or dropped by the dedup policy count as stored. Must hold
+// sessionMutex.
+func (e *Exporter) storeRecord(s *Session, d *Data) error {
+	if s.Resuming && d.SequenceNum <= s.ResumeSeq {
+		s.Stats.Replayed++
+		return nil
+	}
+	write, dup := e.dedupRecord(s, d)
+	if write {
+		t := s.dataTemplate(d)
+		if t == nil {
+			if err := s.mismatchRecord(d); err != nil {
+				return err
+			}
+		} else {
+			rec, err := DecodeRecord(t, d.Record)
+			if err != nil {
+				return s.layoutError(t, d, err)
+			}
+			// An earlier version, its output was closed on the change.
+			if t.Output == nil && d.ConfigID != s.ConfigId {
+				s.exporter.logf("Sess %d: seq %d of config id %d, current %d\n",
+					s.Id, d.SequenceNum, d.ConfigID, s.ConfigId)
+				createFileTemplate(t, s)
+			}
+			if dup && t.DupOutput == nil && !t.MarkDuplicates {
+				createDupFileTemplate(t, s)
+			}
+			if err := t.appendRecord(rec, dup); err != nil {
+				return err
 			}
 		}
 	}
+	e.getDedupWindow(s.Id).Add(s.DocID, d.SequenceNum)
+	return nil
 }
 
-func RemoveSession(m *SessionStop) {
+func (e *Exporter) RemoveSession(m *SessionStop) {
 	sessId := m.Header.SessId
 
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
 
-	if s, ok := sessions[sessId]; ok {
+	if s, ok := e.sessions[sessId]; ok {
 		//Didn't remove from map, just mark a flag
-		closeFiles(s)
-		s.Started = false
+		s.endSession()
+		e.stopDocument(s, m)
+		s.setState(SESS_STOPPED)
 	}
 }
 
-func RcvMsg(msg IPDRMsg) {
-	msgChan <- msg
+// A received msg with the connection it came on.
+type connMsg struct {
+	conn *Connection
+	msg  IPDRMsg
+}
+
+func (e *Exporter) RcvMsg(c *Connection, msg IPDRMsg) {
+	select {
+	case e.msgChan <- connMsg{conn: c, msg: msg}:
+	case <-c.done:
+	}
 }
 
-func handleTimerEvt() {
-	CheckKeepAliveInterval()
-	sessionMutex.Lock()
-	defer sessionMutex.Unlock()
-	for _, s := range sessions {
-		if s.Started {
+func (e *Exporter) handleTimerEvt() {
+	if !e.IsConnected() {
+		return
+	}
+	e.CheckKeepAliveInterval()
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+	for _, s := range e.sessions {
+		if s.IsStarted() && s.Primary {
 			s.CheckAckTimeInterval()
 		}
 	}
 }
 
-func handleKaTimeout() {
+func (e *Exporter) handleKaTimeout() {
+	if !e.IsConnected() {
+		return
+	}
 	msg := NewErrorMsg(ERR_KEEPALIVE_EXPIRED, "")
-	log.Printf("Send %s\n", msg.Desc())
-	SendMsgToExporter(msg.Encode())
+	e.logf("Send %s\n", msg.Desc())
+	e.SendAndCloseConnection(msg.Encode(), errors.New("keepalive expired"))
 }
 
-func handleMsg(msg IPDRMsg) {
+func (e *Exporter) handleMsg(c *Connection, msg IPDRMsg) {
+	// Same as the receiver, a bad msg only affects this exporter.
+	defer func() {
+		if r := recover(); r != nil {
+			e.logf("Panic when handle %s: %v\n", msg.Desc(), r)
+			e.CloseConnection(fmt.Errorf("handle %s panic: %v", msg.Desc(), r))
+		}
+	}()
+
+	// Queued before the connection was lost, the sessions it was for are
+	// gone.
+	if c != e.getActiveConn() {
+		e.logf("Drop %s of a closed connection\n", msg.Desc())
+		return
+	}
+
+	if err := e.checkMsgState(msg); err != nil {
+		e.logf("%s\n", err)
+		errMsg := NewErrorMsg(ERR_MSG_INVALID_FOR_STATE, "")
+		e.logf("Send %s\n", errMsg.Desc())
+		e.SendMsgToExporter(errMsg.Encode())
+		return
+	}
 
 	switch t := msg.(type) {
 	case *Data:
-		UpdateSession(t)
+		e.UpdateSession(t)
 	case *TemplateData:
-		AddSession(t)
+		e.AddSession(t)
+		e.NegotiateTemplates(t)
+	case *ModifyTemplateResponse:
+		e.ModifyTemplateDone(t)
+	case *StartNegotiationReject:
+		e.NegotiationRejected(t)
 	case *SessionStart:
-		StartSession(t)
+		e.StartSession(t)
 	case *SessionStop:
-		RemoveSession(t)
+		e.RemoveSession(t)
 	case *KeepAlive:
+	case *Disconnect:
+		e.handleDisconnect()
+	case *Error:
+		e.handleError(t)
+	case *GetSessionsResponse:
+		e.SetAdvertisedSessions(t.SessionBlocks)
+		t.FlowSessIds = e.SelectSessions(t.SessionBlocks)
+		e.StartFlows(t.FlowSessIds)
+		if e.config.GetTemplates {
+			e.RequestTemplates()
+		}
+	case *GetTemplatesResponse:
+		e.StoreTemplates(t)
+	case *Connect:
+		e.acceptConnect(t)
 	case *ConnectResponse:
-		kaSendInterval = t.KaInterval
-		log.Printf("Set KA send interval to %d\n", kaSendInterval)
-		if kaSendInterval >= 5 {
-			//Send KA 2 seconds before interval.
-			kaSendInterval -= 2
+		e.setConnected(t.Capabilities, t.KaInterval)
+	}
+
+	for _, nextMsg := range msg.RespMsg() {
+		e.logf("Send %s\n", nextMsg.Desc())
+		e.SendMsgToExporter(nextMsg.Encode())
+	}
+}
+
+func (e *Exporter) setConnected(peerCaps Capabilities, kaInterval uint32) {
+	e.SetConnState(CONN_CONNECTED)
+	e.SetCapabilities(peerCaps)
+	e.kaSendInterval = kaInterval
+	e.logf("Set KA send interval to %d\n", e.kaSendInterval)
+	if e.kaSendInterval >= 5 {
+		//Send KA 2 seconds before interval.
+		e.kaSendInterval -= 2
+	}
+}
+
+// Answer the CONNECT of an exporter in passive mode with our capabilities
+// and keepalive interval, as the exporter answers ours.
+func (e *Exporter) acceptConnect(m *Connect) {
+	_, _, vendor, version, ka := e.config.GetConnectParam()
+	resp := &ConnectResponse{
+		Header:       MsgHdr{Version: version, MsgId: CONNECT_RESPONSE},
+		Capabilities: e.advertisedCaps,
+		KaInterval:   ka,
+		VendorId:     UTF8String{Length: uint32(len(vendor)), Str: []byte(vendor)},
+	}
+	e.logf("Exporter vendor %s, KA interval %d\n", m.VendorId.Str, m.KaInterval)
+	e.logf("Send %s\n", resp.Desc())
+	e.SendMsgToExporter(resp.Encode())
+	e.setConnected(m.Capabilities, m.KaInterval)
+}
+
+func (e *Exporter) closeAllSessions() {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	for _, s := range e.sessions {
+		if s.IsStarted() {
+			s.endSession()
+			s.setState(SESS_STOPPED)
+		}
+	}
+}
+
+// Exporter closes the connection, reconnect and start over.
+func (e *Exporter) handleDisconnect() {
+	e.closeAllSessions()
+	e.CloseConnection(errors.New("exporter disconnect"))
+}
+
+// Stop and start the flows again, the exporter resends TEMPLATE_DATA and
+// SESSION_START. Session 0 means all configured sessions.
+func (e *Exporter) RestartFlows(sessId byte) {
+	sessIds := []byte{sessId}
+	if sessId == 0 {
+		sessIds = e.getFlowSessions()
+	}
+
+	e.sessionMutex.Lock()
+	for _, id := range sessIds {
+		if s, ok := e.sessions[id]; ok {
+			if s.IsStarted() {
+				s.endSession()
+			}
+		}
+	}
+	e.sessionMutex.Unlock()
+	e.StartFlows(sessIds)
+
+	for _, id := range sessIds {
+		for _, msg := range []IPDRMsg{NewFlowStopMsg(id, 0, "Restart Flow"), NewFlowStartMsg(id)} {
+			e.logf("Send %s\n", msg.Desc())
+			e.SendMsgToExporter(msg.Encode())
 		}
 	}
+}
 
+func (e *Exporter) handleError(m *Error) {
+	action := GetErrorAction(m.ErrorCode)
+	e.logf("Exporter error %d, action: %s\n", m.ErrorCode, action)
+
+	switch action {
+	case ERR_ACTION_RESET_CONNECTION:
+		e.closeAllSessions()
+		e.CloseConnection(fmt.Errorf("exporter error %d", m.ErrorCode))
+	case ERR_ACTION_RESTART_FLOWS:
+		e.RestartFlows(m.Header.SessId)
+	case ERR_ACTION_BACKOFF:
+		e.closeAllSessions()
+		e.setHoldOff()
+		e.CloseConnection(fmt.Errorf("exporter error %d", m.ErrorCode))
+	}
 }
 
-func SessionMgrInit() {
+// Runs in the session mgr routine, so no DATA is handled after the final ack.
+func (e *Exporter) handleShutdown(done chan struct{}) {
+	defer close(done)
+
+	e.stopping = true
+	c := e.getActiveConn()
+
+	msgs := []IPDRMsg{}
+	e.sessionMutex.RLock()
+	for _, s := range e.sessions {
+		if !s.IsStarted() {
+			continue
+		}
+		if s.UnackedNum > 0 {
+			if seq, ok := s.ackSeq(); ok {
+				msgs = append(msgs, NewDataAckMsg(s.ConfigId, s.Id, seq))
+				s.AckedSeq = seq
+				s.Checkpoint()
+			}
+		}
+		msgs = append(msgs, NewFlowStopMsg(s.Id, 0, "Collector Shutdown"))
+	}
+	e.sessionMutex.RUnlock()
+	msgs = append(msgs, NewDisconnectMsg())
+
+	if c != nil {
+		for _, msg := range msgs {
+			e.logf("Send %s\n", msg.Desc())
+			e.sendMsg(c, msg.Encode())
+		}
+	}
+
+	e.closeAllSessions()
+	e.SetConnState(CONN_STOPPED)
+}
+
+// Reset on the session mgr routine, so no msg is handled at the same time.
+// Msgs of the previous connection still queued after it are dropped by
+// handleMsg, it is no longer the active one.
+func (e *Exporter) resetSessionMgr() {
+	done := make(chan struct{})
+	e.resetChan <- done
+	<-done
+}
+
+// Drop all sessions of the previous connection, the exporter will resend
+// TEMPLATE_DATA and SESSION_START after FLOW_START. Runs in the session mgr
+// routine.
+func (e *Exporter) SessionMgrReset() {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	for _, s := range e.sessions {
+		if s.IsStarted() {
+			s.endSession()
+		}
+	}
+	e.sessions = make(map[byte]*Session)
+	e.advertisedCaps = GetCapabilities()
+	e.capabilities = 0
+	e.advertisedSessions = nil
+	e.flowSessIds = nil
+	e.sessionConfigs = make(map[byte]*ConfigSession)
+	e.setConnState(CONN_CONNECTING)
+	e.kaSendInterval = 300
+	e.UpdateLastKaSendTime()
+	e.UpdateLastKaRcvdTime()
+}
+
+func (e *Exporter) SessionMgrInit() {
 	var sessTimer = time.NewTimer(time.Second * time.Duration(1))
 	go func() {
 		for {
 			select {
 			case <-sessTimer.C:
-				handleTimerEvt()
+				e.handleTimerEvt()
 				sessTimer.Reset(time.Second * time.Duration(1))
-			case <-kaRecvTimer.C:
-				handleKaTimeout()
-				kaRecvTimer.Reset(time.Second * time.Duration(kaRecvInterval))
-			case msg := <-msgChan:
-				handleMsg(msg)
+			case <-e.kaRecvTimer.C:
+				e.handleKaTimeout()
+				e.kaRecvTimer.Reset(time.Second * time.Duration(e.getKaRecvInterval()))
+			case m := <-e.msgChan:
+				e.handleMsg(m.conn, m.msg)
+			case done := <-e.resetChan:
+				e.SessionMgrReset()
+				close(done)
+			case done := <-e.shutdownChan:
+				e.handleShutdown(done)
 			}
 		}
 	}()
-}
\ No newline at end of file
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A file name in use, e.g. two documents started in the same second, must
+// not truncate the records already written.
+func TestCreateOutputFileExists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	const name = "IPDR_RECORD_test.csv"
+	if err = ioutil.WriteFile(name, []byte("acked\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"IPDR_RECORD_test-1.csv", "IPDR_RECORD_test-2.csv"} {
+		file, fileName, err := createOutputFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if fileName != want {
+			t.Errorf("file %s, want %s", fileName, want)
+		}
+	}
+	if b, _ := ioutil.ReadFile(name); string(b) != "acked\n" {
+		t.Errorf("%s truncated to %q", name, b)
+	}
+
+	e := NewExporter(&ConfigExporter{Name: "test"})
+	s := e.newSession(1)
+	s.DocID = []byte{0xab, 0xcd}
+	tp := &Template{TemplateID: 2, TypeName: "TEST-TYPE"}
+	createFileTemplate(tp, s)
+	defer tp.Output.Close()
+	if !strings.Contains(tp.FileName, "_abcd_S1_T2_") {
+		t.Errorf("file %s has no DocumentID", tp.FileName)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+// Choose the sessions to send FLOW_START for from what the exporter
+// advertised. Configured sessions which are not offered are reported.
+func (e *Exporter) SelectSessions(blocks []SessionBlock) []byte {
+	configs := make(map[byte]*ConfigSession)
+
+	for i := range e.config.Sessions {
+		cs := &e.config.Sessions[i]
+		found := false
+		for j := range blocks {
+			if !cs.Match(&blocks[j]) {
+				continue
+			}
+			found = true
+			// First matching config wins, it carries the template config.
+			if _, ok := configs[blocks[j].SessId]; !ok {
+				configs[blocks[j].SessId] = cs
+			}
+		}
+		if !found {
+			e.logf("Warning: configured session %s is not offered by the exporter\n", cs)
+		}
+	}
+
+	sessIds := []byte{}
+	for _, b := range blocks {
+		if _, ok := configs[b.SessId]; ok {
+			sessIds = append(sessIds, b.SessId)
+		}
+	}
+
+	// Without multisession only one session can be streamed.
+	if !e.HasCapability(CAP_MULTISESSION) && len(sessIds) > 1 {
+		e.logf("Multisession not agreed, only start session %d\n", sessIds[0])
+		sessIds = sessIds[:1]
+	}
+	if len(sessIds) == 0 {
+		e.logf("Warning: no advertised session matches the config\n")
+	}
+
+	e.sessionMutex.Lock()
+	e.sessionConfigs = configs
+	e.flowSessIds = sessIds
+	e.sessionMutex.Unlock()
+
+	return sessIds
+}
+
+func (e *Exporter) getSessionConfig(sessId byte) *ConfigSession {
+	e.sessionMutex.RLock()
+	defer e.sessionMutex.RUnlock()
+
+	return e.sessionConfigs[sessId]
+}
+
+func (e *Exporter) getFlowSessions() []byte {
+	e.sessionMutex.RLock()
+	defer e.sessionMutex.RUnlock()
+
+	sessIds := make([]byte, len(e.flowSessIds))
+	copy(sessIds, e.flowSessIds)
+	return sessIds
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"log"
@@ -25,9 +24,13 @@ func (m *SessionStart) Encode() []byte {
 
 func (m *SessionStart) Decode(msg []byte) error {
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
-
-	err := binary.Read(bytesBuffer, endian, &m.Header)
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 53, "document id"); err != nil {
+		return err
+	}
 
 	m.ExporterBootTime = binary.BigEndian.Uint32(msg[8:12])
 	m.FirstRecordSeqNum = binary.BigEndian.Uint64(msg[12:20])
@@ -36,13 +39,13 @@ func (m *SessionStart) Decode(msg []byte) error {
 	m.AckTimeInterval = binary.BigEndian.Uint32(msg[29:33])
 	m.AckSequenceInterval = binary.BigEndian.Uint32(msg[33:37])
 	m.DocumentID = make([]byte, 16)
-	copy(m.DocumentID, msg[37:])
+	copy(m.DocumentID, msg[37:53])
 
 	return err
 }
 
 func (m *SessionStart) Desc() string {
-	return fmt.Sprintf("SESSION_START - id: %d", m.Header.SessId)
+	return fmt.Sprintf("SESSION_START - id: %d, primary: %d", m.Header.SessId, m.Primary)
 }
 
 func (m *SessionStart) RespMsg() []IPDRMsg {
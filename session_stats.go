@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sequence accounting of a session, reset on SESSION_START.
+type SessionStats struct {
+	StartTime       time.Time
+	FirstSeq        uint64
+	NextSeq         uint64
+	Records         uint64
+	Gaps            uint64
+	MissingRecords  uint64
+	Duplicates      uint64
+	Regressions     uint64
+	ExporterDropped uint64
+	// Dedup, records found in the window and records flagged duplicate.
+	Replayed          uint64
+	FlaggedDuplicates uint64
+	WriteErrors       uint64
+	Mismatched        uint64
+	LayoutErrors      uint64
+	// Layout errors by template id.
+	TemplateLayoutErrors map[uint16]uint64
+}
+
+type SeqStatus byte
+
+const (
+	SEQ_OK SeqStatus = iota
+	SEQ_GAP
+	SEQ_DUPLICATE
+	SEQ_REGRESSION
+)
+
+func (s *Session) startStats(m *SessionStart) {
+	s.Stats = SessionStats{
+		StartTime:       time.Now(),
+		FirstSeq:        m.FirstRecordSeqNum,
+		NextSeq:         m.FirstRecordSeqNum,
+		ExporterDropped: m.DroppedRecordCount,
+	}
+	if m.DroppedRecordCount > 0 {
+		s.exporter.logf("Sess %d: exporter dropped %d records before seq %d\n",
+			s.Id, m.DroppedRecordCount, m.FirstRecordSeqNum)
+	}
+}
+
+// Check the sequence number of a DATA against the expected one.
+func (s *Session) checkSequence(seq uint64) SeqStatus {
+	st := &s.Stats
+	st.Records++
+
+	switch {
+	case seq == st.NextSeq:
+		st.NextSeq = seq + 1
+		return SEQ_OK
+	case seq > st.NextSeq:
+		missing := seq - st.NextSeq
+		st.Gaps++
+		st.MissingRecords += missing
+		s.exporter.logf("Sess %d: sequence gap, expect %d, got %d, %d records missing\n",
+			s.Id, st.NextSeq, seq, missing)
+		st.NextSeq = seq + 1
+		return SEQ_GAP
+	case seq >= st.FirstSeq:
+		st.Duplicates++
+		s.exporter.logf("Sess %d: duplicate seq %d, expect %d\n", s.Id, seq, st.NextSeq)
+		return SEQ_DUPLICATE
+	}
+
+	// Below the first seq of the session, the exporter restarted numbering.
+	st.Regressions++
+	s.exporter.logf("Sess %d: sequence regression, expect %d, got %d\n", s.Id, st.NextSeq, seq)
+	st.FirstSeq = seq
+	st.NextSeq = seq + 1
+	return SEQ_REGRESSION
+}
+
+// Layout errors by template, "<id>:<count>" in template id order.
+func (st *SessionStats) templateLayoutErrors(sep string) string {
+	ids := []int{}
+	for id := range st.TemplateLayoutErrors {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	counts := []string{}
+	for _, id := range ids {
+		counts = append(counts, fmt.Sprintf("%d:%d", id, st.TemplateLayoutErrors[uint16(id)]))
+	}
+	return strings.Join(counts, sep)
+}
+
+func (s *Session) Summary() string {
+	st := &s.Stats
+	summary := fmt.Sprintf("sess %d %s doc %x %s: seq %d-%d, records %d, gaps %d, missing %d, "+
+		"duplicates %d, regressions %d, exporter dropped %d, replayed %d, flagged duplicates %d, "+
+		"role changes %d, write errors %d, config mismatch %d, layout errors %d",
+		s.Id, s.Type, s.DocID, roleName(s.Primary), st.FirstSeq, s.LastSeq, st.Records, st.Gaps,
+		st.MissingRecords, st.Duplicates, st.Regressions, st.ExporterDropped, st.Replayed,
+		st.FlaggedDuplicates, s.RoleChanges, st.WriteErrors, st.Mismatched, st.LayoutErrors)
+	if len(st.TemplateLayoutErrors) > 0 {
+		summary += " (template " + st.templateLayoutErrors(", template ") + ")"
+	}
+	return summary
+}
+
+// One row per session document, appended to IPDR_SUMMARY_<exporter>.csv.
+func (s *Session) writeSummary() {
+	st := &s.Stats
+	fileName := fmt.Sprintf("IPDR_SUMMARY_%s.csv", fileNameSafe(s.exporter.Name))
+
+	_, err := os.Stat(fileName)
+	newFile := os.IsNotExist(err)
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		s.exporter.logf("Err: %s\n", err)
+		return
+	}
+	defer file.Close()
+
+	if newFile {
+		fmt.Fprintf(file, "Exporter,Session,Type,DocumentID,Start,End,FirstSeq,LastSeq,"+
+			"Records,Gaps,MissingRecords,Duplicates,Regressions,ExporterDropped,"+
+			"Replayed,FlaggedDuplicates,Role,RoleChanges,WriteErrors,Mismatched,LayoutErrors,"+
+			"TemplateLayoutErrors\n")
+	}
+	fmt.Fprintf(file, "%s,%d,%s,%x,%s,%s,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%s,%d,%d,%d,%d,%s\n",
+		s.exporter.Name, s.Id, s.Type, s.DocID,
+		st.StartTime.Format(time.RFC3339), time.Now().Format(time.RFC3339),
+		st.FirstSeq, s.LastSeq, st.Records, st.Gaps, st.MissingRecords,
+		st.Duplicates, st.Regressions, st.ExporterDropped, st.Replayed, st.FlaggedDuplicates,
+		roleName(s.Primary), s.RoleChanges, st.WriteErrors, st.Mismatched, st.LayoutErrors,
+		st.templateLayoutErrors(" "))
+}
+
+// Close the outputs of a started session and report its accounting.
+func (s *Session) endSession() {
+	closeFiles(s)
+	s.saveDedup()
+	s.exporter.logf("Summary %s\n", s.Summary())
+	s.writeSummary()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTemplateLayoutErrors(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	config.Collector.Mismatch = string(MISMATCH_REJECT)
+	defer func() { config.Collector.Mismatch = "" }()
+
+	e := NewExporter(&ConfigExporter{Name: "stats"})
+	s := e.newSession(1)
+	t1 := &Template{TemplateID: 1}
+	t2 := &Template{TemplateID: 2}
+	for _, tp := range []*Template{t2, t1, t2} {
+		s.layoutError(tp, &Data{}, errors.New("short record"))
+	}
+
+	if s.Stats.LayoutErrors != 3 {
+		t.Errorf("layout errors %d, want 3", s.Stats.LayoutErrors)
+	}
+	if got := s.Stats.templateLayoutErrors(" "); got != "1:1 2:2" {
+		t.Errorf("template layout errors %q, want \"1:1 2:2\"", got)
+	}
+	if summary := s.Summary(); !strings.HasSuffix(summary, "layout errors 3 (template 1:1, template 2:2)") {
+		t.Errorf("summary %q", summary)
+	}
+}
+
+func TestCheckSequence(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tc := range []struct {
+		name   string
+		seqs   []uint64
+		status []SeqStatus
+		want   SessionStats
+	}{
+		{"in order", []uint64{10, 11, 12},
+			[]SeqStatus{SEQ_OK, SEQ_OK, SEQ_OK},
+			SessionStats{Records: 3, FirstSeq: 10, NextSeq: 13}},
+		{"gap", []uint64{10, 13, 14, 20},
+			[]SeqStatus{SEQ_OK, SEQ_GAP, SEQ_OK, SEQ_GAP},
+			SessionStats{Records: 4, FirstSeq: 10, NextSeq: 21, Gaps: 2, MissingRecords: 7}},
+		{"first seq missing", []uint64{12},
+			[]SeqStatus{SEQ_GAP},
+			SessionStats{Records: 1, FirstSeq: 10, NextSeq: 13, Gaps: 1, MissingRecords: 2}},
+		{"duplicate", []uint64{10, 11, 11, 10, 12},
+			[]SeqStatus{SEQ_OK, SEQ_OK, SEQ_DUPLICATE, SEQ_DUPLICATE, SEQ_OK},
+			SessionStats{Records: 5, FirstSeq: 10, NextSeq: 13, Duplicates: 2}},
+		{"regression", []uint64{10, 11, 0, 1, 10},
+			[]SeqStatus{SEQ_OK, SEQ_OK, SEQ_REGRESSION, SEQ_OK, SEQ_GAP},
+			SessionStats{Records: 5, FirstSeq: 0, NextSeq: 11, Regressions: 1, Gaps: 1, MissingRecords: 8}},
+		{"regression below the first seq", []uint64{9},
+			[]SeqStatus{SEQ_REGRESSION},
+			SessionStats{Records: 1, FirstSeq: 9, NextSeq: 10, Regressions: 1}},
+	} {
+		e := NewExporter(&ConfigExporter{Name: "stats"})
+		s := e.newSession(1)
+		s.startStats(&SessionStart{FirstRecordSeqNum: 10})
+		for i, seq := range tc.seqs {
+			if got := s.checkSequence(seq); got != tc.status[i] {
+				t.Errorf("%s: seq %d status %d, want %d", tc.name, seq, got, tc.status[i])
+			}
+		}
+		st := s.Stats
+		if st.Records != tc.want.Records || st.FirstSeq != tc.want.FirstSeq ||
+			st.NextSeq != tc.want.NextSeq || st.Gaps != tc.want.Gaps ||
+			st.MissingRecords != tc.want.MissingRecords || st.Duplicates != tc.want.Duplicates ||
+			st.Regressions != tc.want.Regressions {
+			t.Errorf("%s: stats %+v, want %+v", tc.name, st, tc.want)
+		}
+	}
+}
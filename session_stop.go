@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"log"
@@ -20,13 +19,15 @@ func (m *SessionStop) Encode() []byte {
 
 func (m *SessionStop) Decode(msg []byte) error {
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
-
-	err := binary.Read(bytesBuffer, endian, &m.Header)
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
+	}
+	if err = checkMsgLen(msg, 10, "reason code"); err != nil {
+		return err
+	}
 	m.ReasonCode = binary.BigEndian.Uint16(msg[8:10])
-	m.ReasonInfo.Length = binary.BigEndian.Uint32(msg[10:14])
-	m.ReasonInfo.Str = make([]byte, m.ReasonInfo.Length)
-	copy(m.ReasonInfo.Str, msg[14:])
+	m.ReasonInfo, _, err = decodeUTF8Field(msg[10:], "reason info")
 
 	return err
 }
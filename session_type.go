@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// IPDR session type. It is not carried by IPDR/SP, it comes from the
+// session config "type" or else from the advertised session name and
+// description.
+type SessionType byte
+
+const (
+	SESS_TYPE_UNKNOWN SessionType = iota
+	SESS_TYPE_TIME_INTERVAL
+	SESS_TYPE_ADHOC
+	SESS_TYPE_EVENT
+	SESS_TYPE_TIME_EVENT
+)
+
+func (t SessionType) String() string {
+	switch t {
+	case SESS_TYPE_UNKNOWN:
+		return "unknown"
+	case SESS_TYPE_TIME_INTERVAL:
+		return "time-interval"
+	case SESS_TYPE_ADHOC:
+		return "ad-hoc"
+	case SESS_TYPE_EVENT:
+		return "event"
+	case SESS_TYPE_TIME_EVENT:
+		return "time-event"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(t))
+}
+
+func ParseSessionType(str string) (SessionType, bool) {
+	for t := SESS_TYPE_TIME_INTERVAL; t <= SESS_TYPE_TIME_EVENT; t++ {
+		if t.String() == str {
+			return t, true
+		}
+	}
+	return SESS_TYPE_UNKNOWN, false
+}
+
+// Guess the type from the session name and description, e.g. DOCSIS
+// SAMIS sessions are time-interval.
+func inferSessionType(name, desc string) SessionType {
+	str := strings.ToLower(name + " " + desc)
+	switch {
+	case strings.Contains(str, "time-event") || strings.Contains(str, "time event"):
+		return SESS_TYPE_TIME_EVENT
+	case strings.Contains(str, "ad-hoc") || strings.Contains(str, "adhoc") ||
+		strings.Contains(str, "ad hoc"):
+		return SESS_TYPE_ADHOC
+	case strings.Contains(str, "interval") || strings.Contains(str, "samis"):
+		return SESS_TYPE_TIME_INTERVAL
+	case strings.Contains(str, "event"):
+		return SESS_TYPE_EVENT
+	}
+	return SESS_TYPE_UNKNOWN
+}
+
+// Must hold sessionMutex.
+func (e *Exporter) getSessionType(s *Session) SessionType {
+	if cfg, ok := e.sessionConfigs[s.Id]; ok && cfg != nil && cfg.Type != "" {
+		if t, ok := ParseSessionType(cfg.Type); ok {
+			return t
+		}
+		e.logf("Warning: sess %d unknown type \"%s\"\n", s.Id, cfg.Type)
+	}
+	return inferSessionType(s.Name, s.Description)
+}
+
+// A document is the records between SESSION_START and SESSION_STOP with
+// one DocumentID. It has one output file per template, a SESSION_START
+// with the same DocumentID, e.g. after a reconnect, appends to them. Kept
+// per exporter and session id across reconnects.
+type Document struct {
+	DocID []byte
+	Type  SessionType
+	Start time.Time
+	Files map[uint16]DocumentFile
+}
+
+type DocumentFile struct {
+	FileName string
+	Schema   string
+}
+
+// Columns of a template output, a file is only resumed with the same ones.
+func (t *Template) schema() string {
+	var b strings.Builder
+	for _, f := range t.Fields {
+		if f.IsEnabled {
+			fmt.Fprintf(&b, "%s:%x,", f.FieldName, f.TypeID)
+		}
+	}
+	if t.Enums == ENUM_NAME || t.Enums == ENUM_BOTH {
+		b.WriteString("Enums:" + string(t.Enums) + ",")
+	}
+	if t.MarkDuplicates {
+		b.WriteString("Duplicate")
+	}
+	return b.String()
+}
+
+// Start or resume the document of a SESSION_START. Must hold sessionMutex.
+func (e *Exporter) startDocument(s *Session, m *SessionStart) (*Document, bool) {
+	if doc, ok := e.documents[s.Id]; ok && bytes.Equal(doc.DocID, m.DocumentID) {
+		e.logf("Sess %d %s doc %x resumed, started %s\n",
+			s.Id, s.Type, doc.DocID, doc.Start.Format(time.RFC3339))
+		return doc, true
+	}
+
+	doc := &Document{
+		DocID: make([]byte, 16),
+		Type:  s.Type,
+		Start: time.Now(),
+		Files: make(map[uint16]DocumentFile),
+	}
+	copy(doc.DocID, m.DocumentID)
+	e.documents[s.Id] = doc
+	e.logf("Sess %d %s doc %x started\n", s.Id, s.Type, doc.DocID)
+	return doc, false
+}
+
+// Document boundary on SESSION_STOP. An ad-hoc document is complete, the
+// others may be resumed by their DocumentID.
+func (e *Exporter) stopDocument(s *Session, m *SessionStop) {
+	doc, ok := e.documents[s.Id]
+	if !ok {
+		return
+	}
+	e.logf("Sess %d %s doc %x stopped after %s, reason %d %s\n", s.Id, doc.Type, doc.DocID,
+		time.Since(doc.Start).Truncate(time.Second), m.ReasonCode, m.ReasonInfo.Str)
+	if doc.Type == SESS_TYPE_ADHOC {
+		delete(e.documents, s.Id)
+	}
+}
+
+// Reopen the output of a template of a resumed document, false if the
+// document has no file for it yet.
+func openFileTemplate(t *Template, s *Session) bool {
+	if s.Document == nil {
+		return false
+	}
+	df, ok := s.Document.Files[t.TemplateID]
+	if !ok || df.Schema != t.schema() {
+		return false
+	}
+	fileName := df.FileName
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		s.exporter.logf("Err: %s\n", err)
+		return false
+	}
+	t.FileName = fileName
+	s.exporter.logf("set sess %d template %d name to %s (resumed)", s.Id, t.TemplateID, fileName)
+	t.Output = file
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type StartNegotiation struct {
+	Header MsgHdr
+}
+
+func (m *StartNegotiation) Encode() []byte {
+	b := []byte{}
+	bytesBuffer := bytes.NewBuffer([]byte{})
+
+	binary.Write(bytesBuffer, endian, m.Header)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	return b
+}
+
+func (m *StartNegotiation) Decode(msg []byte) error {
+
+	return errors.New(fmt.Sprintf("Not support decode for %s", m.Desc()))
+}
+
+func (m *StartNegotiation) Desc() string {
+	return fmt.Sprintf("START_NEGOTIATION - id: %d", m.Header.SessId)
+}
+
+func (m *StartNegotiation) RespMsg() []IPDRMsg {
+
+	return nil
+}
+
+func NewStartNegotiationMsg(sessId byte) IPDRMsg {
+	var h MsgHdr = MsgHdr{
+		Version: 2,
+		MsgId:   START_NEGOTIATION,
+		SessId:  sessId,
+		MsgFlag: 0,
+		MsgLen:  8,
+	}
+
+	m := &StartNegotiation{
+		Header: h,
+	}
+
+	return m
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+type StartNegotiationReject struct {
+	Header MsgHdr
+}
+
+func (m *StartNegotiationReject) Encode() []byte {
+	log.Printf("Not support to Encode!\n")
+	return nil
+}
+
+func (m *StartNegotiationReject) Decode(msg []byte) error {
+
+	return decodeHeader(msg, &m.Header)
+}
+
+func (m *StartNegotiationReject) Desc() string {
+	return fmt.Sprintf("START_NEGOTIATION_REJECT - id: %d", m.Header.SessId)
+}
+
+func (m *StartNegotiationReject) RespMsg() []IPDRMsg {
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+)
+
+// IPDR/SP connection state, kept per exporter.
+type ConnState byte
+
+const (
+	CONN_CONNECTING ConnState = iota
+	CONN_CONNECTED
+	CONN_STOPPED
+)
+
+func (st ConnState) String() string {
+	switch st {
+	case CONN_CONNECTING:
+		return "connecting"
+	case CONN_CONNECTED:
+		return "connected"
+	case CONN_STOPPED:
+		return "stopped"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(st))
+}
+
+// IPDR/SP session state. A Session exists once FLOW_START is sent for it.
+type SessState byte
+
+const (
+	SESS_FLOW_STARTED SessState = iota
+	SESS_TEMPLATE_RECEIVED
+	SESS_TEMPLATE_NEGOTIATING
+	SESS_STARTED
+	SESS_STOPPED
+)
+
+func (st SessState) String() string {
+	switch st {
+	case SESS_FLOW_STARTED:
+		return "flow-started"
+	case SESS_TEMPLATE_RECEIVED:
+		return "template-received"
+	case SESS_TEMPLATE_NEGOTIATING:
+		return "template-negotiating"
+	case SESS_STARTED:
+		return "session-started"
+	case SESS_STOPPED:
+		return "stopped"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(st))
+}
+
+// Must hold sessionMutex.
+func (e *Exporter) setConnState(st ConnState) {
+	if e.state != st {
+		e.logf("Conn state %s -> %s\n", e.state, st)
+		e.state = st
+	}
+}
+
+func (e *Exporter) SetConnState(st ConnState) {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+	e.setConnState(st)
+}
+
+func (s *Session) setState(st SessState) {
+	if s.State != st {
+		s.exporter.logf("Sess %d state %s -> %s\n", s.Id, s.State, st)
+		s.State = st
+	}
+}
+
+func roleName(primary bool) string {
+	if primary {
+		return "primary"
+	}
+	return "secondary"
+}
+
+func (s *Session) setRole(primary bool) {
+	switch {
+	case !s.RoleKnown:
+		s.exporter.logf("Sess %d role %s\n", s.Id, roleName(primary))
+	case s.Primary != primary:
+		s.RoleChanges++
+		s.exporter.logf("Sess %d role %s -> %s\n", s.Id, roleName(s.Primary), roleName(primary))
+	}
+	s.Primary = primary
+	s.RoleKnown = true
+}
+
+func (s *Session) IsStarted() bool {
+	return s.State == SESS_STARTED
+}
+
+// Check an inbound msg against the connection and session state, nil if
+// the msg is valid now.
+func (e *Exporter) checkMsgState(msg IPDRMsg) error {
+	e.sessionMutex.RLock()
+	defer e.sessionMutex.RUnlock()
+
+	var sessId byte
+	var valid []SessState
+
+	switch t := msg.(type) {
+	case *KeepAlive, *Error, *Disconnect:
+		return nil
+	case *Connect:
+		// Only from the side which opened the connection.
+		if !IsPassiveMode() || e.state != CONN_CONNECTING {
+			return fmt.Errorf("%s invalid for conn state %s", msg.Desc(), e.state)
+		}
+		return nil
+	case *ConnectResponse:
+		if IsPassiveMode() || e.state != CONN_CONNECTING {
+			return fmt.Errorf("%s invalid for conn state %s", msg.Desc(), e.state)
+		}
+		return nil
+	case *GetSessionsResponse, *GetTemplatesResponse:
+		if e.state != CONN_CONNECTED {
+			return fmt.Errorf("%s invalid for conn state %s", msg.Desc(), e.state)
+		}
+		return nil
+	case *TemplateData:
+		sessId = t.Header.SessId
+		valid = []SessState{SESS_FLOW_STARTED, SESS_TEMPLATE_RECEIVED,
+			SESS_TEMPLATE_NEGOTIATING, SESS_STOPPED}
+	case *ModifyTemplateResponse:
+		sessId = t.Header.SessId
+		valid = []SessState{SESS_TEMPLATE_NEGOTIATING}
+	case *StartNegotiationReject:
+		sessId = t.Header.SessId
+		valid = []SessState{SESS_FLOW_STARTED, SESS_TEMPLATE_RECEIVED,
+			SESS_TEMPLATE_NEGOTIATING, SESS_STARTED, SESS_STOPPED}
+	case *SessionStart:
+		sessId = t.Header.SessId
+		valid = []SessState{SESS_TEMPLATE_RECEIVED, SESS_STOPPED}
+	case *SessionStop:
+		sessId = t.Header.SessId
+		valid = []SessState{SESS_STARTED}
+	case *Data:
+		sessId = t.Header.SessId
+		valid = []SessState{SESS_STARTED}
+	}
+
+	if e.state != CONN_CONNECTED {
+		return fmt.Errorf("%s invalid for conn state %s", msg.Desc(), e.state)
+	}
+
+	s, ok := e.sessions[sessId]
+	if !ok {
+		return fmt.Errorf("%s invalid, no flow started for session %d", msg.Desc(), sessId)
+	}
+	for _, st := range valid {
+		if s.State == st {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s invalid for sess %d state %s", msg.Desc(), sessId, s.State)
+}
@@ -26,54 +26,174 @@ type TemplateData struct {
 	ConfigID  uint16
 	Flags     uint8
 	Templates []TemplateBlock
+	// Structure definitions following the templates, with the structures
+	// capability. Decoded by DecodeStructures once the capability is known.
+	Structures    []TemplateBlock
+	structureData []byte
+	// Set by the exporter owning the msg when the templates are negotiated.
+	Modify           *ModifyTemplate
+	StartNegotiation bool
 }
 
-func (m *TemplateData) Encode() []byte {
-	log.Printf("Not support to Encode!\n")
-	return nil
+// Template flags of TEMPLATE_DATA / MODIFY_TEMPLATE.
+const (
+	TEMPLATE_FLAG_NEGOTIABLE uint8 = 0x01
+)
+
+func (f *FieldDescriptor) Encode() []byte {
+	b := []byte{}
+	bytesBuffer := bytes.NewBuffer([]byte{})
+
+	binary.Write(bytesBuffer, endian, f.TypeID)
+	binary.Write(bytesBuffer, endian, f.FieldID)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	b = append(b, f.FieldName.Encode()...)
+	b = append(b, f.IsEnabled)
+
+	return b
 }
 
-func (m *TemplateData) Decode(msg []byte) error {
+func (tb *TemplateBlock) Encode() []byte {
+	b := []byte{}
+	bytesBuffer := bytes.NewBuffer([]byte{})
 
-	bytesBuffer := bytes.NewBuffer(msg[:8])
+	binary.Write(bytesBuffer, endian, tb.TemplateID)
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
 
-	err := binary.Read(bytesBuffer, endian, &m.Header)
-	m.ConfigID = binary.BigEndian.Uint16(msg[8:10])
-	m.Flags = msg[10]
+	b = append(b, tb.SchemaName.Encode()...)
+	b = append(b, tb.TypeName.Encode()...)
+
+	binary.Write(bytesBuffer, endian, uint32(len(tb.Fields)))
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	for _, f := range tb.Fields {
+		b = append(b, f.Encode()...)
+	}
+
+	return b
+}
 
-	numTemplates := binary.BigEndian.Uint32(msg[11:15])
-	msg = msg[15:]
+// Decode the template list shared by TEMPLATE_DATA, MODIFY_TEMPLATE and
+// MODIFY_TEMPLATE_RESPONSE.
+func decodeTemplateBlocks(msg []byte) ([]TemplateBlock, error) {
+	templates, _, err := decodeTemplateBlocksRest(msg)
+	return templates, err
+}
+
+// Also returns the bytes after the template list.
+func decodeTemplateBlocksRest(msg []byte) ([]TemplateBlock, []byte, error) {
+	var err error
+	templates := []TemplateBlock{}
+
+	if err = checkMsgLen(msg, 4, "template count"); err != nil {
+		return nil, nil, err
+	}
+	numTemplates := binary.BigEndian.Uint32(msg[:4])
+	msg = msg[4:]
 	var msgLen uint32
 	//log.Printf("num temps: %d\n", numTemplates)
 	for i := uint32(0); i < numTemplates; i++ {
 		tb := TemplateBlock{}
+		if err = checkMsgLen(msg, 2, "template id"); err != nil {
+			return nil, nil, err
+		}
 		tb.TemplateID = binary.BigEndian.Uint16(msg[:2])
 		msg = msg[2:]
-		tb.SchemaName, msgLen = DecodeUTF8String(msg)
+		tb.SchemaName, msgLen, err = decodeUTF8Field(msg, "schema name")
+		if err != nil {
+			return nil, nil, err
+		}
 		msg = msg[msgLen:]
-		tb.TypeName, msgLen = DecodeUTF8String(msg)
+		tb.TypeName, msgLen, err = decodeUTF8Field(msg, "type name")
+		if err != nil {
+			return nil, nil, err
+		}
 		msg = msg[msgLen:]
+		if err = checkMsgLen(msg, 4, "field count"); err != nil {
+			return nil, nil, err
+		}
 		numFields := binary.BigEndian.Uint32(msg[:4])
 		msg = msg[4:]
 		for j := uint32(0); j < numFields; j++ {
 			f := FieldDescriptor{}
+			if err = checkMsgLen(msg, 8, "field id"); err != nil {
+				return nil, nil, err
+			}
 			f.TypeID = binary.BigEndian.Uint32(msg[:4])
 			f.FieldID = binary.BigEndian.Uint32(msg[4:8])
 			msg = msg[8:]
-			f.FieldName, msgLen = DecodeUTF8String(msg)
+			f.FieldName, msgLen, err = decodeUTF8Field(msg, "field name")
+			if err != nil {
+				return nil, nil, err
+			}
 			msg = msg[msgLen:]
+			if err = checkMsgLen(msg, 1, "field enabled"); err != nil {
+				return nil, nil, err
+			}
 			f.IsEnabled = msg[0]
 			msg = msg[1:]
 			tb.Fields = append(tb.Fields, f)
 		}
-		m.Templates = append(m.Templates, tb)
+		templates = append(templates, tb)
+	}
+
+	return templates, msg, nil
+}
+
+func encodeTemplateBlocks(templates []TemplateBlock) []byte {
+	b := []byte{}
+	bytesBuffer := bytes.NewBuffer([]byte{})
+
+	binary.Write(bytesBuffer, endian, uint32(len(templates)))
+	b = append(b, bytesBuffer.Bytes()...)
+	bytesBuffer.Reset()
+
+	for i := range templates {
+		b = append(b, templates[i].Encode()...)
+	}
+
+	return b
+}
+
+func (m *TemplateData) Encode() []byte {
+	log.Printf("Not support to Encode!\n")
+	return nil
+}
+
+func (m *TemplateData) Decode(msg []byte) error {
+
+	err := decodeHeader(msg, &m.Header)
+	if err != nil {
+		return err
 	}
+	if err = checkMsgLen(msg, 11, "flags"); err != nil {
+		return err
+	}
+	m.ConfigID = binary.BigEndian.Uint16(msg[8:10])
+	m.Flags = msg[10]
+
+	m.Templates, m.structureData, err = decodeTemplateBlocksRest(msg[11:])
 
 	//log.Printf("Decode Template data: % +v\n", m)
 
 	return err
 }
 
+// Structure definitions after the templates, only to be decoded when the
+// structures capability was agreed.
+func (m *TemplateData) DecodeStructures() error {
+	if len(m.structureData) == 0 {
+		return nil
+	}
+	var err error
+	m.Structures, err = decodeTemplateBlocks(m.structureData)
+	return err
+}
+
 func (m *TemplateData) Desc() string {
 	return fmt.Sprintf("TEMPLATE_DATA - id: %d", m.Header.SessId)
 }
@@ -81,19 +201,17 @@ func (m *TemplateData) Desc() string {
 func (m *TemplateData) RespMsg() []IPDRMsg {
 	msgs := []IPDRMsg{}
 
-	var h MsgHdr = MsgHdr{
-		Version: 2,
-		MsgId:   FINAL_TEMPLATE_DATA_ACK,
-		SessId:  m.Header.SessId,
-		MsgFlag: 0,
-		MsgLen:  8,
+	// FINAL_TEMPLATE_DATA_ACK follows MODIFY_TEMPLATE_RESPONSE.
+	if m.Modify != nil {
+		msgs = append(msgs, m.Modify)
+		return msgs
 	}
 
-	templateAck := &TemplateDataAck{
-		Header: h,
-	}
+	msgs = append(msgs, NewTemplateDataAckMsg(m.Header.SessId))
 
-	msgs = append(msgs, templateAck)
+	if m.StartNegotiation {
+		msgs = append(msgs, NewStartNegotiationMsg(m.Header.SessId))
+	}
 
 	return msgs
-}
\ No newline at end of file
+}
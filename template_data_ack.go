@@ -35,3 +35,19 @@ func (m *TemplateDataAck) RespMsg() []IPDRMsg {
 
 	return nil
 }
+
+func NewTemplateDataAckMsg(sessId byte) IPDRMsg {
+	var h MsgHdr = MsgHdr{
+		Version: 2,
+		MsgId:   FINAL_TEMPLATE_DATA_ACK,
+		SessId:  sessId,
+		MsgFlag: 0,
+		MsgLen:  8,
+	}
+
+	m := &TemplateDataAck{
+		Header: h,
+	}
+
+	return m
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestTemplateDataStructuresCapability(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// A template with a structure field, then the structure definition.
+	msg := testMsg(TEMPLATE_DATA, uint16(1), uint8(0),
+		uint32(1), uint16(1), testUTF8("schema"), testUTF8("TEST-TYPE"), uint32(1),
+		uint32(TYPE_STRUCTURE_FLAG|5), uint32(1), testUTF8("Member"), uint8(1),
+		uint32(1), uint16(5), testUTF8("schema"), testUTF8("MEMBER"), uint32(1),
+		uint32(UINT), uint32(1), testUTF8("Id"), uint8(1))
+	record := []byte{0, 0, 0, 4, 0, 0, 0, 9}
+
+	for _, agreed := range []bool{false, true} {
+		m, err := msgDecode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := NewExporter(&ConfigExporter{Name: "caps"})
+		if agreed {
+			e.capabilities = CAP_STRUCTURES
+		}
+		e.AddSession(m.(*TemplateData))
+		s := e.sessions[1]
+
+		rec, err := DecodeRecord(s.findTemplate(1), record)
+		switch {
+		case agreed && err != nil:
+			t.Errorf("agreed: %v", err)
+		case agreed && rec.Get("Member").(*StructValue).Fields[0].Value != uint32(9):
+			t.Errorf("agreed: decoded %+v", rec.Fields)
+		case !agreed && (s.Structures != nil || err == nil):
+			t.Errorf("not agreed: structures %v, err %v", s.Structures, err)
+		}
+	}
+}
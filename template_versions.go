@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// What to do with a DATA whose ConfigID or template does not match the
+// session templates, or whose record does not match the template layout,
+// by the collector "config-mismatch".
+type MismatchPolicy string
+
+const (
+	// Write the raw record to IPDR_QUARANTINE_<exporter>_..._S<id>.csv, the default.
+	MISMATCH_QUARANTINE MismatchPolicy = "quarantine"
+	// Discard the record.
+	MISMATCH_REJECT MismatchPolicy = "reject"
+)
+
+// Templates of a session are kept by ConfigID, the current set is
+// s.Templates. Must hold sessionMutex.
+func (s *Session) setConfigId(configId uint16) {
+	if s.Versions == nil {
+		s.Versions = make(map[uint16][]*Template)
+	}
+	if s.ConfigId != configId && len(s.Versions) > 0 {
+		s.exporter.logf("Sess %d config id %d -> %d\n", s.Id, s.ConfigId, configId)
+	}
+	s.ConfigId = configId
+	s.Versions[configId] = append([]*Template{}, s.Templates...)
+}
+
+func (s *Session) findTemplate(templateId uint16) *Template {
+	for _, t := range s.Templates {
+		if t.TemplateID == templateId {
+			return t
+		}
+	}
+	return nil
+}
+
+// Template of a DATA. Records of an earlier ConfigID, e.g. unacked ones
+// sent again after the templates changed, are decoded with the templates
+// of their version.
+func (s *Session) dataTemplate(d *Data) *Template {
+	if d.ConfigID == s.ConfigId {
+		return s.findTemplate(d.TemplateID)
+	}
+	for _, t := range s.Versions[d.ConfigID] {
+		if t.TemplateID == d.TemplateID {
+			return t
+		}
+	}
+	return nil
+}
+
+// The current templates and those of earlier ConfigIDs with an output
+// open for their records.
+func (s *Session) outputTemplates() []*Template {
+	templates := append([]*Template{}, s.Templates...)
+	seen := make(map[*Template]bool)
+	for _, t := range templates {
+		seen[t] = true
+	}
+	for _, version := range s.Versions {
+		for _, t := range version {
+			if t.Output != nil && !seen[t] {
+				seen[t] = true
+				templates = append(templates, t)
+			}
+		}
+	}
+	return templates
+}
+
+// Close the outputs of a redefined template, so each file has one schema.
+// A started session goes on writing to new files.
+func (s *Session) rotateTemplate(old, nt *Template) {
+	if old == nil || old.Output == nil {
+		return
+	}
+	s.exporter.logf("Sess %d template %d redefined, rotate %s\n", s.Id, old.TemplateID, old.FileName)
+	closeTemplateFiles(old)
+	if s.IsStarted() && s.Primary {
+		nt.MarkDuplicates = old.MarkDuplicates
+		nt.Enums = old.Enums
+		createFileTemplate(nt, s)
+	}
+}
+
+// A DATA with no template in its ConfigID, quarantined records count as
+// stored.
+func (s *Session) mismatchRecord(d *Data) error {
+	reason := "unknown template"
+	if _, known := s.Versions[d.ConfigID]; !known {
+		reason = fmt.Sprintf("unknown config id (current %d)", s.ConfigId)
+	}
+	s.Stats.Mismatched++
+	if s.Stats.Mismatched == 1 {
+		s.exporter.logf("Sess %d: seq %d config id %d template %d, %s\n",
+			s.Id, d.SequenceNum, d.ConfigID, d.TemplateID, reason)
+	}
+	return s.quarantineRecord(d, reason)
+}
+
+// A record not matching its template layout, reported per template.
+func (s *Session) layoutError(t *Template, d *Data, err error) error {
+	t.LayoutErrors++
+	s.Stats.LayoutErrors++
+	if s.Stats.TemplateLayoutErrors == nil {
+		s.Stats.TemplateLayoutErrors = make(map[uint16]uint64)
+	}
+	s.Stats.TemplateLayoutErrors[t.TemplateID]++
+	if t.LayoutErrors == 1 {
+		s.exporter.logf("Sess %d: seq %d template %d layout mismatch: %s\n",
+			s.Id, d.SequenceNum, t.TemplateID, err)
+	}
+	return s.quarantineRecord(d, "layout: "+err.Error())
+}
+
+func (s *Session) quarantineRecord(d *Data, reason string) error {
+	if GetMismatchPolicy() == MISMATCH_REJECT {
+		return nil
+	}
+	if s.Quarantine == nil {
+		fileName := fmt.Sprintf("IPDR_QUARANTINE_%s_%s_S%d.csv",
+			fileNameSafe(s.exporter.Name), time.Now().Format("2006-01-02-15-04-05"), s.Id)
+		file, fileName, err := createOutputFile(fileName)
+		if err != nil {
+			return err
+		}
+		s.exporter.logf("set sess %d quarantine name to %s", s.Id, fileName)
+		s.Quarantine = file
+		fmt.Fprintf(file, "Sequence,ConfigID,TemplateID,Reason,Record\n")
+	}
+	bufferedWriter := bufio.NewWriter(s.Quarantine)
+	fmt.Fprintf(bufferedWriter, "%d,%d,%d,%s,%s\n", d.SequenceNum, d.ConfigID, d.TemplateID,
+		csvQuote(reason), hex.EncodeToString(d.Record))
+	if err := bufferedWriter.Flush(); err != nil {
+		return err
+	}
+	s.QuarantineDirty = true
+	return nil
+}
+
+func (s *Session) syncQuarantine() error {
+	if s.Quarantine == nil || !s.QuarantineDirty {
+		return nil
+	}
+	if err := s.Quarantine.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %s", s.Quarantine.Name(), err)
+	}
+	s.QuarantineDirty = false
+	return nil
+}
+
+func (s *Session) closeQuarantine() {
+	if s.Quarantine != nil {
+		s.Quarantine.Sync()
+		s.Quarantine.Close()
+		s.Quarantine = nil
+	}
+	s.QuarantineDirty = false
+}
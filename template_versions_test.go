@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// DATA of the previous ConfigID after the templates changed is decoded
+// with the templates of its version, to a file of its own.
+func TestEarlierConfigIdData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	e, s := testAckSession(t)
+	v1 := s.findTemplate(1)
+	e.setTemplates(s, 2, []TemplateBlock{{
+		TemplateID: 1,
+		TypeName:   UTF8String{Str: []byte("TEST-TYPE")},
+		Fields: []FieldDescriptor{
+			{TypeID: uint32(UINT), FieldID: 1, FieldName: UTF8String{Str: []byte("Counter")}, IsEnabled: 1},
+			{TypeID: uint32(UINT), FieldID: 2, FieldName: UTF8String{Str: []byte("Other")}, IsEnabled: 1},
+		},
+	}})
+	v2 := s.findTemplate(1)
+	if v1.Output != nil || v2.Output == nil {
+		t.Fatal("template 1 not rotated")
+	}
+
+	for _, d := range []*Data{
+		{ConfigID: 2, SequenceNum: 0, Record: []byte{0, 0, 0, 8, 0, 0, 0, 1, 0, 0, 0, 2}},
+		{ConfigID: 1, SequenceNum: 1, Record: []byte{0, 0, 0, 4, 0, 0, 0, 3}},
+		{ConfigID: 3, SequenceNum: 2, Record: []byte{0, 0, 0, 4, 0, 0, 0, 4}},
+	} {
+		d.Header = MsgHdr{MsgId: DATA, SessId: 1}
+		d.TemplateID = 1
+		e.handleMsg(nil, d)
+	}
+	if s.Stats.LayoutErrors != 0 || s.Stats.Mismatched != 1 || s.WriteFailed {
+		t.Fatalf("layout errors %d, mismatched %d, write failed %v, want 0, 1, false",
+			s.Stats.LayoutErrors, s.Stats.Mismatched, s.WriteFailed)
+	}
+	if v1.Output == nil || v1.FileName == v2.FileName {
+		t.Fatal("no output of config id 1")
+	}
+	if df := s.Document.Files[1]; df.FileName != v2.FileName {
+		t.Errorf("document file %s, want %s", df.FileName, v2.FileName)
+	}
+
+	s.sendAck()
+	if acks := testAcks(e); len(acks) == 0 || acks[len(acks)-1] != 2 {
+		t.Errorf("acks %v, want up to 2", acks)
+	}
+	files := map[string]string{v1.FileName: "Counter\n3\n", v2.FileName: "Counter,Other\n1,2\n"}
+	closeFiles(s)
+	e.SessionMgrReset()
+	if v1.Output != nil {
+		t.Error("output of config id 1 not closed")
+	}
+	for fileName, want := range files {
+		if b, err := ioutil.ReadFile(fileName); err != nil || string(b) != want {
+			t.Errorf("%s is %q, want %q", fileName, b, want)
+		}
+	}
+}
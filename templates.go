@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (e *Exporter) SetAdvertisedSessions(blocks []SessionBlock) {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	e.advertisedSessions = blocks
+}
+
+// Send GET_TEMPLATES for every session the exporter advertised, the
+// responses are dumped as they arrive.
+func (e *Exporter) RequestTemplates() {
+	if !e.IsConnected() {
+		e.logf("Not connected, can't get templates\n")
+		return
+	}
+
+	e.sessionMutex.Lock()
+	msgs := []IPDRMsg{}
+	for _, b := range e.advertisedSessions {
+		e.requestId++
+		msgs = append(msgs, NewGetTemplatesMsg(b.SessId, e.requestId))
+	}
+	e.sessionMutex.Unlock()
+
+	if len(msgs) == 0 {
+		e.logf("No session advertised yet, can't get templates\n")
+		return
+	}
+	for _, msg := range msgs {
+		e.logf("Send %s\n", msg.Desc())
+		e.SendMsgToExporter(msg.Encode())
+	}
+}
+
+func (e *Exporter) StoreTemplates(m *GetTemplatesResponse) {
+	ts := &TemplateSet{
+		SessId:   m.Header.SessId,
+		ConfigId: m.ConfigID,
+		Updated:  time.Now(),
+	}
+	e.sessionMutex.Lock()
+	// Annotated as the TEMPLATE_DATA ones, for the units in the dump.
+	for _, tb := range m.Templates {
+		t := newTemplate(tb)
+		e.validateTemplate(ts.SessId, t)
+		ts.Templates = append(ts.Templates, t)
+	}
+	e.templateSets[ts.SessId] = ts
+	e.sessionMutex.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(ts.Dump(), "\n"), "\n") {
+		e.logf("%s\n", line)
+	}
+}
+
+func (e *Exporter) GetTemplateSet(sessId byte) *TemplateSet {
+	e.sessionMutex.RLock()
+	defer e.sessionMutex.RUnlock()
+
+	return e.templateSets[sessId]
+}
+
+// Readable schema of the templates, one line per field.
+func (ts *TemplateSet) Dump() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Templates of sess %d, config id %d:\n", ts.SessId, ts.ConfigId)
+	templates := make([]*Template, len(ts.Templates))
+	copy(templates, ts.Templates)
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].TemplateID < templates[j].TemplateID
+	})
+	for _, t := range templates {
+		fmt.Fprintf(&b, "  template %d %s, schema %s\n", t.TemplateID, t.TypeName, t.SchemaName)
+		for _, f := range t.Fields {
+			enabled := "enabled"
+			if !f.IsEnabled {
+				enabled = "disabled"
+			}
+			units := ""
+			if f.Schema != nil && f.Schema.Units != "" {
+				units = " (" + f.Schema.Units + ")"
+			}
+			fmt.Fprintf(&b, "    %-6d %-40s %-14s %s%s\n",
+				f.FieldID, f.FieldName, TypeID(f.TypeID), enabled, units)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStoreTemplatesAnnotated(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	e := NewExporter(&ConfigExporter{Name: "templates"})
+	e.StoreTemplates(&GetTemplatesResponse{
+		Header:   MsgHdr{MsgId: GET_TEMPLATES_RESPONSE, SessId: 1},
+		ConfigID: 1,
+		Templates: []TemplateBlock{{
+			TemplateID: 1,
+			SchemaName: UTF8String{Str: []byte(DOCSIS_NAMESPACE + "DOCSIS-SAMIS-TYPE-1")},
+			TypeName:   UTF8String{Str: []byte("DOCSIS-Type")},
+			Fields: []FieldDescriptor{
+				{TypeID: uint32(ULONG), FieldID: 1, FieldName: UTF8String{Str: []byte("ServiceOctetsPassed")}, IsEnabled: 1},
+			},
+		}},
+	})
+
+	ts := e.GetTemplateSet(1)
+	if ts == nil || len(ts.Templates) != 1 || ts.Templates[0].Schema == nil {
+		t.Fatal("template not annotated")
+	}
+	if dump := ts.Dump(); !strings.Contains(dump, "ServiceOctetsPassed") || !strings.Contains(dump, "(octets)") {
+		t.Errorf("dump has no units:\n%s", dump)
+	}
+}
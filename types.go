@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A TypeID not built in, e.g. a vendor derived type. Length gives the
+// bytes the field takes from input, which may be longer, Decode gets
+// exactly them. Format and Encode are optional, values are then written
+// by their Go type and can not be encoded.
+type TypeDef struct {
+	Id      TypeID
+	Name    string
+	Numeric bool // unquoted in JSON
+	Length  func(input []byte) (uint32, error)
+	Decode  func(input []byte) (interface{}, error)
+	Format  func(v interface{}) string
+	Encode  func(v interface{}) ([]byte, error)
+}
+
+var (
+	typeRegistry      = map[TypeID]*TypeDef{}
+	typeRegistryMutex sync.RWMutex
+)
+
+func isBuiltinType(id TypeID) bool {
+	_, ok := typeName[id]
+	return ok
+}
+
+// Register a type, built in and complex types can not be replaced.
+func RegisterType(def *TypeDef) error {
+	if def.Length == nil || def.Decode == nil {
+		return fmt.Errorf("type %s: length and decode are required", def.Name)
+	}
+	if isBuiltinType(def.Id) || def.Id.IsComplex() {
+		return fmt.Errorf("type 0x%08x is reserved", uint32(def.Id))
+	}
+
+	typeRegistryMutex.Lock()
+	defer typeRegistryMutex.Unlock()
+
+	if old, ok := typeRegistry[def.Id]; ok {
+		return fmt.Errorf("type 0x%08x already registered as %s", uint32(def.Id), old.Name)
+	}
+	typeRegistry[def.Id] = def
+	return nil
+}
+
+func lookupType(id TypeID) *TypeDef {
+	typeRegistryMutex.RLock()
+	defer typeRegistryMutex.RUnlock()
+
+	return typeRegistry[id]
+}
+
+// Type declared in the collector "types" config. Either a fixed width or
+// length prefixed by a uint32, rendered as "hex", "integer" or "string".
+type ConfigType struct {
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	Width          uint32 `json:"width"`
+	LengthPrefixed bool   `json:"length-prefixed"`
+	Render         string `json:"render"`
+	Signed         bool   `json:"signed"`
+}
+
+func (ct *ConfigType) TypeDef() (*TypeDef, error) {
+	id, err := strconv.ParseUint(ct.Id, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("type %s: bad id \"%s\"", ct.Name, ct.Id)
+	}
+	if ct.LengthPrefixed == (ct.Width != 0) {
+		return nil, fmt.Errorf("type %s: either width or length-prefixed", ct.Name)
+	}
+
+	def := &TypeDef{Id: TypeID(id), Name: ct.Name}
+	prefix := uint32(0)
+	if ct.LengthPrefixed {
+		prefix = 4
+		def.Length = xdrOpaqueLength
+	} else {
+		width := ct.Width
+		def.Length = func(input []byte) (uint32, error) {
+			return width, nil
+		}
+	}
+
+	// The bytes of the field, Decode may be given short input.
+	length := def.Length
+	field := func(input []byte) ([]byte, error) {
+		l, err := length(input)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(l) > uint64(len(input)) {
+			return nil, ErrMsgTruncated
+		}
+		return input[prefix:l], nil
+	}
+
+	switch ct.Render {
+	case "hex", "":
+		def.Decode = func(input []byte) (interface{}, error) {
+			f, err := field(input)
+			if err != nil {
+				return nil, err
+			}
+			b := make([]byte, len(f))
+			copy(b, f)
+			return b, nil
+		}
+	case "string":
+		def.Decode = func(input []byte) (interface{}, error) {
+			f, err := field(input)
+			if err != nil {
+				return nil, err
+			}
+			return string(f), nil
+		}
+	case "integer":
+		if ct.LengthPrefixed || (ct.Width != 1 && ct.Width != 2 && ct.Width != 4 && ct.Width != 8) {
+			return nil, fmt.Errorf("type %s: integer needs width 1, 2, 4 or 8", ct.Name)
+		}
+		signed := ct.Signed
+		def.Numeric = true
+		def.Decode = func(input []byte) (interface{}, error) {
+			input, err := field(input)
+			if err != nil {
+				return nil, err
+			}
+			var n uint64
+			for _, b := range input {
+				n = n<<8 | uint64(b)
+			}
+			if !signed {
+				return n, nil
+			}
+			shift := 64 - 8*uint(len(input))
+			return int64(n<<shift) >> shift, nil
+		}
+	default:
+		return nil, fmt.Errorf("type %s: unknown render \"%s\"", ct.Name, ct.Render)
+	}
+
+	width := ct.Width
+	def.Encode = func(v interface{}) ([]byte, error) {
+		var b []byte
+		switch val := v.(type) {
+		case []byte:
+			b = val
+		case string:
+			b = []byte(val)
+		default:
+			n, ok := xdrInteger(v)
+			if !ok || !def.Numeric {
+				return nil, xdrEncodeTypeError(def.Id, v)
+			}
+			b = make([]byte, 8)
+			binary.BigEndian.PutUint64(b, n)
+			b = b[8-width:]
+		}
+		if prefix == 0 {
+			if uint32(len(b)) != width {
+				return nil, fmt.Errorf("%s: %d bytes, width %d", def.Name, len(b), width)
+			}
+			return b, nil
+		}
+		l := make([]byte, 4, 4+len(b))
+		binary.BigEndian.PutUint32(l, uint32(len(b)))
+		return append(l, b...), nil
+	}
+	return def, nil
+}
+
+func RegisterConfigTypes() error {
+	var errs []string
+	for i := range config.Collector.Types {
+		ct := &config.Collector.Types[i]
+		def, err := ct.TypeDef()
+		if err == nil {
+			err = RegisterType(def)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Printf("Registered type %s 0x%08x\n", def.Name, uint32(def.Id))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
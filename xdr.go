@@ -3,7 +3,7 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
-	"github.com/stellar/go-xdr/xdr"
+	"math"
 )
 
 type TypeID uint32
@@ -34,86 +34,53 @@ const (
 	MACADDR      TypeID = 0x00000723
 )
 
-func XdrDecode(typeID TypeID, input []byte) (string, error) {
-
-	var ret string
+var typeName map[TypeID]string = map[TypeID]string{
+	INT:          "int",
+	UINT:         "unsignedInt",
+	LONG:         "long",
+	ULONG:        "unsignedLong",
+	FLOAT:        "float",
+	DOUBLE:       "double",
+	HEXBINARY:    "hexBinary",
+	STRING:       "string",
+	BOOLEAN:      "boolean",
+	BYTE:         "byte",
+	UBYTE:        "unsignedByte",
+	SHORT:        "short",
+	USHORT:       "unsignedShort",
+	DATETIME:     "dateTime",
+	DATETIMEMSEC: "dateTimeMsec",
+	IPV4ADDR:     "ipV4Addr",
+	IPV6ADDR:     "ipV6Addr",
+	IPADDR:       "ipAddr",
+	UUID:         "UUID",
+	DATETIMEUSEC: "dateTimeUsec",
+	MACADDR:      "macAddress",
+}
 
-	switch typeID {
-	case SHORT, USHORT:
-		ret = fmt.Sprintf("%d", binary.BigEndian.Uint16(input))
-	case INT, UINT, DATETIME:
-		ret = fmt.Sprintf("%d", binary.BigEndian.Uint32(input))
-	case LONG, ULONG, DATETIMEMSEC, DATETIMEUSEC:
-		ret = fmt.Sprintf("%d", binary.BigEndian.Uint64(input))
-	case FLOAT:
-		var f float32
-		xdr.Unmarshal(input, &f)
-		ret = fmt.Sprintf("%f", f)
-	case DOUBLE:
-		var d float64
-		xdr.Unmarshal(input, &d)
-		ret = fmt.Sprintf("%f", d)
-	case HEXBINARY:
-		input = input[4:]
-		ret = fmt.Sprintf("[% x]", input)
-	case STRING:
-		input = input[4:]
-		ret = string(input)
-	case IPV4ADDR:
-		ret = fmt.Sprintf("%d.%d.%d.%d", input[0], input[1], input[2], input[3])
-	case IPV6ADDR:
-		input = input[4:]
-		ret = fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x:%02x:%02x",
-			binary.BigEndian.Uint16(input),
-			binary.BigEndian.Uint16(input[2:]),
-			binary.BigEndian.Uint16(input[4:]),
-			binary.BigEndian.Uint16(input[6:]),
-			binary.BigEndian.Uint16(input[8:]),
-			binary.BigEndian.Uint16(input[10:]),
-			binary.BigEndian.Uint16(input[12:]),
-			binary.BigEndian.Uint16(input[14:]))
-	case IPADDR:
-		var length = binary.BigEndian.Uint32(input)
-		input = input[4:]
-		if length == 4 {
-			ret = fmt.Sprintf("%d.%d.%d.%d", input[0], input[1], input[2], input[3])
-		} else {
-			ret = fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x:%02x:%02x",
-				binary.BigEndian.Uint16(input),
-				binary.BigEndian.Uint16(input[2:]),
-				binary.BigEndian.Uint16(input[4:]),
-				binary.BigEndian.Uint16(input[6:]),
-				binary.BigEndian.Uint16(input[8:]),
-				binary.BigEndian.Uint16(input[10:]),
-				binary.BigEndian.Uint16(input[12:]),
-				binary.BigEndian.Uint16(input[14:]))
-		}
-	case UUID:
-		input = input[4:]
-		ret = fmt.Sprintf("%02x%02x-%02x%02x-%02x%02x-%02x%02x",
-			binary.BigEndian.Uint16(input),
-			binary.BigEndian.Uint16(input[2:]),
-			binary.BigEndian.Uint16(input[4:]),
-			binary.BigEndian.Uint16(input[6:]),
-			binary.BigEndian.Uint16(input[8:]),
-			binary.BigEndian.Uint16(input[10:]),
-			binary.BigEndian.Uint16(input[12:]),
-			binary.BigEndian.Uint16(input[14:]))
-	case MACADDR:
-		input = input[2:]
-		ret = fmt.Sprintf("%02x%02x.%02x%02x.%02x%02x",
-			input[0], input[1], input[2], input[3], input[4], input[5])
-	case BOOLEAN:
-		if input[0] == 0 {
-			ret = "false"
-		} else {
-			ret = "true"
-		}
-	case BYTE, UBYTE:
-		ret = fmt.Sprintf("%d", input[0])
+func (t TypeID) String() string {
+	if name, ok := typeName[t]; ok {
+		return name
+	}
+	if def := lookupType(t); def != nil {
+		return def.Name
+	}
+	if t.IsArray() {
+		return "array of " + t.ElemType().String()
 	}
+	if t.IsStructure() {
+		return fmt.Sprintf("structure %d", t.StructureID())
+	}
+	return fmt.Sprintf("0x%08x", uint32(t))
+}
 
-	return ret, nil
+// Text of a basic field as written to CSV.
+func XdrDecode(typeID TypeID, input []byte) (string, error) {
+	v, err := XdrValue(typeID, input)
+	if err != nil {
+		return "", err
+	}
+	return FormatValue(typeID, v), nil
 }
 
 func XdrTypeLength(typeID TypeID, len []byte) uint32 {
@@ -123,7 +90,11 @@ func XdrTypeLength(typeID TypeID, len []byte) uint32 {
 	case LONG, ULONG, DOUBLE, DATETIMEMSEC, DATETIMEUSEC, MACADDR:
 		return 8
 	case HEXBINARY, STRING, IPADDR:
-		return binary.BigEndian.Uint32(len[:4]) + 4
+		if length, err := xdrOpaqueLength(len); err == nil {
+			return length
+		}
+		// Longer than any input, so it reads as truncated.
+		return math.MaxUint32
 	case BOOLEAN, BYTE, UBYTE:
 		return 1
 	case SHORT, USHORT:
@@ -132,6 +103,24 @@ func XdrTypeLength(typeID TypeID, len []byte) uint32 {
 		return 20
 
 	}
+	if def := lookupType(typeID); def != nil {
+		if length, err := def.Length(len); err == nil {
+			return length
+		}
+	}
 
 	return 0
-}
\ No newline at end of file
+}
+
+// Length of a field prefixed by a uint32 count of bytes, prefix included.
+// The count is checked against the bytes left, so it can not wrap around.
+func xdrOpaqueLength(input []byte) (uint32, error) {
+	if len(input) < 4 {
+		return 0, ErrMsgTruncated
+	}
+	length := binary.BigEndian.Uint32(input[:4])
+	if uint64(length) > uint64(len(input)-4) {
+		return 0, ErrMsgTruncated
+	}
+	return length + 4, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Wire bytes of a value, the inverse of decodeValue. Takes the Go types of
+// the Record model, integer types also from any Go integer.
+func XdrEncode(typeID TypeID, v interface{}) ([]byte, error) {
+	bytesBuffer := bytes.NewBuffer([]byte{})
+	if err := xdrEncode(bytesBuffer, typeID, v, 0); err != nil {
+		return nil, err
+	}
+	return bytesBuffer.Bytes(), nil
+}
+
+// Data.Record of a record, the length then the fields.
+func EncodeRecord(rec *Record) ([]byte, error) {
+	bytesBuffer := bytes.NewBuffer([]byte{})
+	for _, fv := range rec.Fields {
+		if err := xdrEncode(bytesBuffer, fv.Type, fv.Value, 0); err != nil {
+			return nil, fmt.Errorf("field %s: %w", fv.Name, err)
+		}
+	}
+
+	b := make([]byte, 4, 4+bytesBuffer.Len())
+	binary.BigEndian.PutUint32(b, uint32(bytesBuffer.Len()))
+	return append(b, bytesBuffer.Bytes()...), nil
+}
+
+func xdrEncode(bytesBuffer *bytes.Buffer, typeID TypeID, v interface{}, depth int) error {
+	if depth > maxStructureDepth {
+		return errStructureDepth
+	}
+
+	switch {
+	case typeID.IsArray():
+		a, ok := v.(*ArrayValue)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		binary.Write(bytesBuffer, endian, uint32(len(a.Elems)))
+		for i, e := range a.Elems {
+			if err := xdrEncode(bytesBuffer, typeID.ElemType(), e, depth+1); err != nil {
+				return fmt.Errorf("array element %d: %w", i, err)
+			}
+		}
+		return nil
+
+	case typeID.IsStructure():
+		sv, ok := v.(*StructValue)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		for _, f := range sv.Fields {
+			if err := xdrEncode(bytesBuffer, f.Type, f.Value, depth+1); err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+		return nil
+	}
+
+	switch typeID {
+	case INT, UINT, LONG, ULONG, SHORT, USHORT, BYTE, UBYTE:
+		n, ok := xdrInteger(v)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		switch typeID {
+		case INT, UINT:
+			binary.Write(bytesBuffer, endian, uint32(n))
+		case LONG, ULONG:
+			binary.Write(bytesBuffer, endian, n)
+		case SHORT, USHORT:
+			binary.Write(bytesBuffer, endian, uint16(n))
+		default:
+			bytesBuffer.WriteByte(byte(n))
+		}
+	case BOOLEAN:
+		b, ok := v.(bool)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		if b {
+			bytesBuffer.WriteByte(1)
+		} else {
+			bytesBuffer.WriteByte(0)
+		}
+	case FLOAT:
+		f, ok := v.(float32)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		binary.Write(bytesBuffer, endian, f)
+	case DOUBLE:
+		d, ok := v.(float64)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		binary.Write(bytesBuffer, endian, d)
+	case DATETIME, DATETIMEMSEC, DATETIMEUSEC:
+		t, ok := v.(time.Time)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		switch typeID {
+		case DATETIME:
+			binary.Write(bytesBuffer, endian, uint32(t.Unix()))
+		case DATETIMEMSEC:
+			binary.Write(bytesBuffer, endian, uint64(t.UnixNano()/1e6))
+		default:
+			binary.Write(bytesBuffer, endian, uint64(t.UnixNano()/1e3))
+		}
+	case HEXBINARY:
+		b, ok := v.([]byte)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		xdrEncodeOpaque(bytesBuffer, b)
+	case STRING:
+		s, ok := v.(string)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		xdrEncodeOpaque(bytesBuffer, []byte(s))
+	case IPV4ADDR:
+		ip, ok := v.(net.IP)
+		if !ok || ip.To4() == nil {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		bytesBuffer.Write(ip.To4())
+	case IPV6ADDR:
+		ip, ok := v.(net.IP)
+		if !ok || ip.To16() == nil {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		xdrEncodeOpaque(bytesBuffer, ip.To16())
+	case IPADDR:
+		ip, ok := v.(net.IP)
+		if !ok || ip.To16() == nil {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			xdrEncodeOpaque(bytesBuffer, ip4)
+		} else {
+			xdrEncodeOpaque(bytesBuffer, ip.To16())
+		}
+	case UUID:
+		u, ok := v.(UUIDValue)
+		if !ok {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		xdrEncodeOpaque(bytesBuffer, u[:])
+	case MACADDR:
+		mac, ok := v.(net.HardwareAddr)
+		if !ok || len(mac) != 6 {
+			return xdrEncodeTypeError(typeID, v)
+		}
+		bytesBuffer.Write([]byte{0, 0})
+		bytesBuffer.Write(mac)
+	default:
+		def := lookupType(typeID)
+		if def == nil || def.Encode == nil {
+			return fmt.Errorf("can not encode type %s", typeID)
+		}
+		b, err := def.Encode(v)
+		if err != nil {
+			return err
+		}
+		bytesBuffer.Write(b)
+	}
+	return nil
+}
+
+// Length prefixed bytes, as hexBinary, string, ipV6Addr, ipAddr and UUID.
+func xdrEncodeOpaque(bytesBuffer *bytes.Buffer, b []byte) {
+	binary.Write(bytesBuffer, endian, uint32(len(b)))
+	bytesBuffer.Write(b)
+}
+
+func xdrInteger(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case int:
+		return uint64(n), true
+	case int8:
+		return uint64(n), true
+	case int16:
+		return uint64(n), true
+	case int32:
+		return uint64(n), true
+	case int64:
+		return uint64(n), true
+	case uint:
+		return uint64(n), true
+	case uint8:
+		return uint64(n), true
+	case uint16:
+		return uint64(n), true
+	case uint32:
+		return uint64(n), true
+	case uint64:
+		return n, true
+	}
+	return 0, false
+}
+
+func xdrEncodeTypeError(typeID TypeID, v interface{}) error {
+	return fmt.Errorf("can not encode %T as %s", v, typeID)
+}
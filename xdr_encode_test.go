@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestXdrRoundTrip(t *testing.T) {
+	when := time.Unix(1700000000, 0).UTC()
+	whenMsec := time.Unix(1700000000, 123e6).UTC()
+	whenUsec := time.Unix(1700000000, 123456e3).UTC()
+	uuid := UUIDValue{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	structs := Structures{
+		1: {Id: 1, Name: "Member", Fields: []*Field{
+			{TypeID: uint32(USHORT), FieldName: "Id", IsEnabled: true},
+			{TypeID: uint32(STRING), FieldName: "Name", IsEnabled: true},
+		}},
+	}
+	member := &StructValue{Structure: structs[1], Fields: []FieldValue{
+		{Name: "Id", Type: USHORT, Value: uint16(7)},
+		{Name: "Name", Type: STRING, Value: "ab"},
+	}}
+
+	vendorInt := &ConfigType{Id: "0x00010101", Name: "testCounter", Width: 2, Render: "integer", Signed: true}
+	vendorOpaque := &ConfigType{Id: "0x00010102", Name: "testOpaque", LengthPrefixed: true, Render: "hex"}
+	for _, ct := range []*ConfigType{vendorInt, vendorOpaque} {
+		def, err := ct.TypeDef()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lookupType(def.Id) == nil {
+			if err = RegisterType(def); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cases := []struct {
+		typeID TypeID
+		value  interface{}
+		wire   []byte
+	}{
+		{INT, int32(-2), []byte{0xff, 0xff, 0xff, 0xfe}},
+		{UINT, uint32(0x01020304), []byte{1, 2, 3, 4}},
+		{LONG, int64(-1), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{ULONG, uint64(0x0102030405060708), []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{FLOAT, float32(1.5), []byte{0x3f, 0xc0, 0, 0}},
+		{DOUBLE, float64(-2), []byte{0xc0, 0, 0, 0, 0, 0, 0, 0}},
+		{HEXBINARY, []byte{0xde, 0xad}, []byte{0, 0, 0, 2, 0xde, 0xad}},
+		{HEXBINARY, []byte{}, []byte{0, 0, 0, 0}},
+		{STRING, "abc", []byte{0, 0, 0, 3, 'a', 'b', 'c'}},
+		{BOOLEAN, true, []byte{1}},
+		{BOOLEAN, false, []byte{0}},
+		{BYTE, int8(-1), []byte{0xff}},
+		{UBYTE, uint8(200), []byte{200}},
+		{SHORT, int16(-2), []byte{0xff, 0xfe}},
+		{USHORT, uint16(0x0102), []byte{1, 2}},
+		{DATETIME, when, []byte{0x65, 0x53, 0xf1, 0x00}},
+		{DATETIMEMSEC, whenMsec, []byte{0, 0, 0x01, 0x8b, 0xcf, 0xe5, 0x68, 0x7b}},
+		{DATETIMEUSEC, whenUsec, []byte{0, 0x06, 0x0a, 0x24, 0x18, 0x20, 0x22, 0x40}},
+		{IPV4ADDR, net.IPv4(10, 0, 0, 1), []byte{10, 0, 0, 1}},
+		{IPV6ADDR, net.ParseIP("2001:db8::1"),
+			[]byte{0, 0, 0, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{IPADDR, net.IP{192, 168, 1, 2}, []byte{0, 0, 0, 4, 192, 168, 1, 2}},
+		{IPADDR, net.ParseIP("2001:db8::2"),
+			[]byte{0, 0, 0, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}},
+		{UUID, uuid, append([]byte{0, 0, 0, 16}, uuid[:]...)},
+		{MACADDR, net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}, []byte{0, 0, 0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+		{TYPE_STRUCTURE_FLAG | 1, member, []byte{0, 7, 0, 0, 0, 2, 'a', 'b'}},
+		{TYPE_ARRAY_FLAG | UINT, &ArrayValue{ElemType: UINT, Elems: []interface{}{uint32(1), uint32(2)}},
+			[]byte{0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2}},
+		{TYPE_ARRAY_FLAG | STRING, &ArrayValue{ElemType: STRING},
+			[]byte{0, 0, 0, 0}},
+		{TYPE_ARRAY_FLAG | TYPE_STRUCTURE_FLAG | 1,
+			&ArrayValue{ElemType: TYPE_STRUCTURE_FLAG | 1, Elems: []interface{}{member}},
+			[]byte{0, 0, 0, 1, 0, 7, 0, 0, 0, 2, 'a', 'b'}},
+		{0x00010101, int64(-3), []byte{0xff, 0xfd}},
+		{0x00010102, []byte{1, 2, 3}, []byte{0, 0, 0, 3, 1, 2, 3}},
+	}
+
+	for _, c := range cases {
+		b, err := XdrEncode(c.typeID, c.value)
+		if err != nil {
+			t.Errorf("%s: encode: %v", c.typeID, err)
+			continue
+		}
+		if !bytes.Equal(b, c.wire) {
+			t.Errorf("%s: encoded % x, want % x", c.typeID, b, c.wire)
+		}
+		v, length, err := decodeValue(c.typeID, c.wire, structs, 0)
+		if err != nil {
+			t.Errorf("%s: decode: %v", c.typeID, err)
+			continue
+		}
+		if int(length) != len(c.wire) {
+			t.Errorf("%s: decoded %d bytes, want %d", c.typeID, length, len(c.wire))
+		}
+		if !xdrValueEqual(v, c.value) {
+			t.Errorf("%s: decoded %#v, want %#v", c.typeID, v, c.value)
+		}
+	}
+}
+
+func xdrValueEqual(a, b interface{}) bool {
+	switch va := a.(type) {
+	case time.Time:
+		vb, ok := b.(time.Time)
+		return ok && va.Equal(vb)
+	case net.IP:
+		vb, ok := b.(net.IP)
+		return ok && va.Equal(vb)
+	case float64:
+		vb, ok := b.(float64)
+		return ok && (va == vb || math.IsNaN(va) && math.IsNaN(vb))
+	case *ArrayValue:
+		vb, ok := b.(*ArrayValue)
+		if !ok || va.ElemType != vb.ElemType || len(va.Elems) != len(vb.Elems) {
+			return false
+		}
+		for i := range va.Elems {
+			if !xdrValueEqual(va.Elems[i], vb.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *StructValue:
+		vb, ok := b.(*StructValue)
+		if !ok || len(va.Fields) != len(vb.Fields) {
+			return false
+		}
+		for i := range va.Fields {
+			if va.Fields[i].Name != vb.Fields[i].Name || !xdrValueEqual(va.Fields[i].Value, vb.Fields[i].Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestEncodeRecordRoundTrip(t *testing.T) {
+	tp := &Template{Fields: []*Field{
+		{TypeID: uint32(UINT), FieldName: "Counter", IsEnabled: true},
+		{TypeID: uint32(STRING), FieldName: "Disabled", IsEnabled: false},
+		{TypeID: uint32(STRING), FieldName: "Name", IsEnabled: true},
+	}}
+	wire := []byte{0, 0, 0, 11, 0, 0, 0, 9, 0, 0, 0, 3, 'a', 'b', 'c'}
+	rec, err := DecodeRecord(tp, wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Get("Counter") != uint32(9) || rec.Get("Name") != "abc" || rec.Get("Disabled") != nil {
+		t.Fatalf("decoded %+v", rec.Fields)
+	}
+	b, err := EncodeRecord(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, wire) {
+		t.Errorf("encoded % x, want % x", b, wire)
+	}
+}